@@ -0,0 +1,78 @@
+// Command framemeta prints the metadata embedded in saved snapshots and
+// MJPEG recordings, one JSON line per frame, so they can be re-analyzed
+// with the detections that were drawn on them.
+//
+//	go run ./cmd/framemeta snapshot.jpg recording.mjpg
+//
+// Recordings may be raw concatenated JPEGs or a saved multipart stream.
+// With -all, frames without metadata are listed with an "error" field.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"Garage48/internal/camera"
+	"Garage48/internal/jpegfast"
+)
+
+type record struct {
+	File   string `json:"file"`
+	Frame  int    `json:"frame"`
+	Offset int    `json:"offset"`
+	*camera.FrameMeta
+	Error string `json:"error,omitempty"`
+}
+
+func main() {
+	all := flag.Bool("all", false, "also print frames without metadata")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-all] file.jpg|file.mjpg ...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	for _, name := range flag.Args() {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		frame := 0
+		for off := 0; ; {
+			i := bytes.Index(data[off:], []byte{0xFF, 0xD8, 0xFF})
+			if i < 0 {
+				break
+			}
+			off += i
+			rec := record{File: name, Frame: frame, Offset: off}
+			info, err := jpegfast.Analyze(data[off:])
+			if err != nil {
+				rec.Error = err.Error()
+				off += 2 // resync on the next SOI
+			} else {
+				meta, err := camera.ReadFrameMeta(data[off : off+info.Size])
+				if err != nil {
+					rec.Error = err.Error()
+				} else {
+					rec.FrameMeta = &meta
+				}
+				off += info.Size
+			}
+			frame++
+			if rec.FrameMeta != nil || *all {
+				if err := out.Encode(rec); err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 from fastapi import FastAPI, UploadFile, File
 from fastapi.responses import JSONResponse
 from PIL import Image
+from typing import List
 import io
 import os
 import platform
@@ -89,6 +90,21 @@ def health():
             pass
     return info
 
+def boxes_of(r):
+    boxes = []
+    names = r.names
+    for b in r.boxes:
+        x1, y1, x2, y2 = map(lambda v: int(v.item()), b.xyxy[0])
+        cls_id = int(b.cls.item())
+        conf_v = float(b.conf.item())
+        boxes.append({
+            "label": names.get(cls_id, str(cls_id)),
+            "class_id": cls_id,
+            "conf": conf_v,
+            "x1": x1, "y1": y1, "x2": x2, "y2": y2
+        })
+    return boxes
+
 @app.post("/detect")
 async def detect(file: UploadFile = File(...), conf: float = CONF_DEFAULT, iou: float = IOU_DEFAULT):
     content = await file.read()
@@ -103,22 +119,28 @@ async def detect(file: UploadFile = File(...), conf: float = CONF_DEFAULT, iou:
         verbose=False,
     )
 
-    boxes = []
-    if results:
-        r = results[0]
-        names = r.names
-        for b in r.boxes:
-            x1, y1, x2, y2 = map(lambda v: int(v.item()), b.xyxy[0])
-            cls_id = int(b.cls.item())
-            conf_v = float(b.conf.item())
-            boxes.append({
-                "label": names.get(cls_id, str(cls_id)),
-                "class_id": cls_id,
-                "conf": conf_v,
-                "x1": x1, "y1": y1, "x2": x2, "y2": y2
-            })
+    boxes = boxes_of(results[0]) if results else []
     return JSONResponse({"boxes": boxes})
 
+@app.post("/detect_batch")
+async def detect_batch(files: List[UploadFile] = File(...), conf: float = CONF_DEFAULT, iou: float = IOU_DEFAULT):
+    # One predict call for all frames lets the backend run them as a batch.
+    # Results keep the order of the uploaded files.
+    imgs = []
+    for f in files:
+        content = await f.read()
+        imgs.append(Image.open(io.BytesIO(content)).convert("RGB"))
+
+    results = MODEL.predict(
+        imgs,
+        conf=conf,
+        iou=iou,
+        imgsz=IMG_SIZE,
+        verbose=False,
+    )
+
+    return JSONResponse({"results": [{"boxes": boxes_of(r)} for r in results]})
+
 if __name__ == "__main__":
     port = int(os.getenv("DETECTOR_PORT", "9000"))
     print(f"[detector] backend={BACKEND} imgsz={IMG_SIZE} model={MODEL_PATH}")
@@ -4,51 +4,121 @@ import (
 	"bytes"
 	"context"
 	"image"
-	"image/color"
 	"image/jpeg"
 	"log"
 	"net/url"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"Garage48/internal/detector"
+	"Garage48/internal/jpegfast"
 )
 
 type Camera struct {
-	id     string
-	url    string
-	maxFPS int
-	detURL string
-	det    *detector.Client
+	id      string
+	url     string
+	maxFPS  int
+	det     detector.Detector
+	sched   *detector.Scheduler // when set, detection goes through it instead of det
+	prep    *detPrep
+	filter  *boxFilter // nil keeps every detection
+	smooth  *smoother  // nil draws results as they come
+	capture *capturer  // nil without a dataset
+	opts    Options
 
-	mu     sync.RWMutex
-	latest []byte
-	szW    int
-	szH    int
+	mu        sync.RWMutex
+	latest    []byte
+	latestRaw []byte // camera frame latest was made from, before any processing
+	latestSeq uint64 // notifier sequence number of latest
+	szW       int
+	szH       int
+	lastPub   time.Time
+	fps       float64 // exponential moving average of the publish rate
 
 	// detection state
 	lastBoxesMu sync.RWMutex
-	lastBoxes   []detector.Box
+	lastDet     detector.Result // after the class filter
+	lastAll     detector.Result // before it, for privacy masking
+	lastDetJPG  []byte          // the frame lastAll was found on
 	lastAt      time.Time
 
-	detLatest atomic.Value // stores []byte (last frame to detect)
+	detLatest atomic.Pointer[detFrame] // last frame to detect
+
+	tamper  *tamperAnalyzer // nil when disabled
+	privacy *privacyFilter  // nil when disabled; forces every frame through decode/encode
+	boxes   *boxRenderer
+	osd     *osdRenderer // nil when disabled; forces every frame through decode/encode
+	xform   *transformer // identity unless configured; adjustable at runtime
+
+	enc    *jpegfast.Encoder
+	encBuf []byte // encode scratch, owned by the frame loop
+	xfBuf  []byte // lossless transform scratch, owned by the frame loop
+
+	stream   atomic.Pointer[StreamStats] // of the running MJPEG client
+	variants variantCache
 
 	notif *notifier
 	run   atomic.Bool
 }
 
-func NewCamera(id, url, detectorURL string, maxFPS int) *Camera {
-	return &Camera{
+// detectionMaxAge is how long a result is drawn when no newer one arrives.
+const detectionMaxAge = 500 * time.Millisecond
+
+// newAnnotatedEncoder builds the encoder for frames that were drawn on.
+// Quality is lowered a bit for speed; 4:2:0 matches what phones send.
+// workers <= 0 uses every CPU.
+func newAnnotatedEncoder(workers int) *jpegfast.Encoder {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	enc, _ := jpegfast.NewEncoder(jpegfast.EncodeConfig{
+		Quality:     80,
+		Subsampling: jpegfast.Subsample420,
+		Workers:     workers,
+	})
+	return enc
+}
+
+// NewCamera creates a stopped camera. det is the server-wide detector, used
+// unless opts.Detector selects another backend.
+func NewCamera(id, url string, det detector.Detector, maxFPS int, opts Options) *Camera {
+	c := &Camera{
 		id:     id,
 		url:    url,
 		maxFPS: maxFPS,
-		detURL: detectorURL,
-		det:    detector.New(detectorURL),
+		opts:   opts,
+		boxes:  newBoxRenderer(opts.Boxes),
+		xform:  newTransformer(opts.Transform),
+		enc:    newAnnotatedEncoder(opts.EncodeWorkers),
 		notif:  newNotifier(),
 	}
+	det, err := detector.Open(opts.Detector, det)
+	if err != nil {
+		log.Printf("[%s] detector disabled: %v", id, err)
+		det = detector.Noop{}
+	}
+	c.det = det
+	c.prep = newDetPrep(opts.DetectSize, det)
+	c.filter = newBoxFilter(opts.Filter)
+	c.smooth = newSmoother(opts.Smooth, detectionMaxAge)
+	if opts.Tamper.Enabled {
+		c.tamper = newTamperAnalyzer(opts.Tamper)
+	}
+	if opts.Privacy.enabled() {
+		c.privacy = newPrivacyFilter(opts.Privacy)
+	}
+	if opts.OSD.Enabled {
+		c.osd = newOSDRenderer(opts.OSD, c.Name())
+	}
+	return c
 }
 
+// UseScheduler routes the camera's detection requests through a shared
+// scheduler that batches them with other cameras. Call it before Start.
+func (c *Camera) UseScheduler(s *detector.Scheduler) { c.sched = s }
+
 func (c *Camera) Start() {
 	if !c.run.CompareAndSwap(false, true) {
 		return
@@ -76,6 +146,7 @@ func (c *Camera) loopMJPEG() {
 	defer cancel()
 
 	client := NewMJPEGClient(c.url)
+	c.stream.Store(&client.Stats)
 	frames := make(chan []byte, 1)
 	go func() {
 		if err := client.Stream(ctx, frames); err != nil {
@@ -85,6 +156,9 @@ func (c *Camera) loopMJPEG() {
 
 	// Start detector worker: always process the most recent frame, drop older
 	go c.detectWorker(ctx, 150*time.Millisecond, 300*time.Millisecond)
+	if c.tamper != nil {
+		go c.tamperWorker(ctx, time.Duration(c.tamper.cfg.IntervalMS)*time.Millisecond)
+	}
 
 	tk := newTicker(c.maxFPS)
 	for c.run.Load() {
@@ -96,100 +170,328 @@ func (c *Camera) loopMJPEG() {
 				time.Sleep(20 * time.Millisecond)
 				continue
 			}
-			// publish current frame for detection (latest-only)
-			c.detLatest.Store(jpegBytes)
+			// publish current frame for detection (latest-only); only the
+			// frame loop publishes, so it will carry the next sequence number
+			c.detLatest.Store(&detFrame{jpg: jpegBytes, seq: c.notif.Seq() + 1})
 		default:
 			time.Sleep(5 * time.Millisecond)
 			continue
 		}
 
+		capturedAt := time.Now()
+
 		// Decide: if we have fresh detections, draw; else pass-through
-		boxes, fresh := c.getFreshBoxes(500 * time.Millisecond)
-		if !fresh || len(boxes) == 0 {
-			// Pass through original JPEG: minimal latency
-			c.publish(jpegBytes, 0, 0)
-			continue
+		det, fresh := c.getFreshBoxes(detectionMaxAge)
+		draw := fresh && len(det.Boxes) > 0
+		if !draw && c.privacy == nil && c.osd == nil {
+			if !c.xform.active() {
+				// Pass through original JPEG: minimal latency
+				c.publish(jpegBytes, jpegBytes, 0, 0, capturedAt, nil)
+				continue
+			}
+			// Rotations, flips and MCU-aligned crops are done on the DCT
+			// coefficients: no generation loss and far cheaper than a re-encode.
+			if lt, ok := c.xform.lossless(); ok {
+				if out, err := jpegfast.AppendTransform(c.encBuf[:0], jpegBytes, lt); err == nil {
+					c.encBuf = out
+					c.publish(append([]byte(nil), out...), jpegBytes, 0, 0, capturedAt, nil)
+					continue
+				}
+			}
+		}
+
+		// Without privacy masking only the MCUs under boxes and the OSD change.
+		if c.privacy == nil {
+			if out, w, h, drawn, ok := c.annotatePatch(jpegBytes, det, draw, capturedAt); ok {
+				c.publish(append([]byte(nil), out...), jpegBytes, w, h, capturedAt, drawn)
+				continue
+			}
 		}
 
 		// Draw boxes onto current frame
 		img, err := jpeg.Decode(bytes.NewReader(jpegBytes))
 		if err != nil {
 			log.Printf("[%s] jpeg decode error: %v", c.id, err)
-			// fallback to pass-through
-			c.publish(jpegBytes, 0, 0)
+			// fallback to pass-through, unless it would leak masked regions
+			if c.privacy == nil {
+				c.publish(jpegBytes, jpegBytes, 0, 0, capturedAt, nil)
+			}
 			continue
 		}
 		rgba := toRGBA(img)
-		w, h := rgba.Bounds().Dx(), rgba.Bounds().Dy()
+		src := rgba.Bounds().Size()
 
-		rects := make([]image.Rectangle, 0, len(boxes))
-		for _, b := range boxes {
-			rects = append(rects, image.Rect(b.X1, b.Y1, b.X2, b.Y2))
+		// Privacy works in source coordinates so masks stay put under live zoom/pan.
+		if c.privacy != nil {
+			all, detAt := c.allDetections()
+			c.privacy.apply(rgba, all.Scale(src.X, src.Y), detAt, time.Now())
+		}
+		rgba = c.xform.apply(rgba)
+		w, h := rgba.Bounds().Dx(), rgba.Bounds().Dy()
+		var drawn []detector.Box
+		if draw {
+			drawn = c.xform.mapBoxes(det.Scale(src.X, src.Y), src)
+			c.boxes.draw(rgba, rgba.Bounds(), drawn)
+		}
+		if c.osd != nil {
+			c.osd.draw(rgba, rgba.Bounds(), capturedAt, c.FPS())
 		}
-		drawBoxes(rgba, rects, color.RGBA{0, 255, 0, 255})
 
-		var buf bytes.Buffer
-		// Lower quality a bit for speed
-		if err := jpeg.Encode(&buf, rgba, &jpeg.Options{Quality: 80}); err != nil {
+		// Encode into the reused scratch buffer, then hand out an exact-size copy:
+		// published frames are shared with HTTP readers and must not be overwritten.
+		out, err := c.enc.AppendEncode(c.encBuf[:0], rgba)
+		if err != nil {
 			log.Printf("[%s] jpeg encode error: %v", c.id, err)
-			// fallback to pass-through
-			c.publish(jpegBytes, 0, 0)
+			// fallback to pass-through, unless it would leak masked regions
+			if c.privacy == nil {
+				c.publish(jpegBytes, jpegBytes, 0, 0, capturedAt, nil)
+			}
 			continue
 		}
-		c.publish(buf.Bytes(), w, h)
+		c.encBuf = out
+		c.publish(append([]byte(nil), out...), jpegBytes, w, h, capturedAt, drawn)
 	}
 }
 
+// annotatePatch draws boxes and the OSD by re-encoding only the MCUs they
+// touch, after a lossless transform if one is configured, and returns the
+// boxes as drawn, in output pixels. It reports false
+// for zoomed or unaligned transforms and JPEGs jpegfast cannot parse, which
+// need the full decode path.
+func (c *Camera) annotatePatch(jpg []byte, det detector.Result, draw bool, at time.Time) ([]byte, int, int, []detector.Box, bool) {
+	hdr, err := jpegfast.DecodeHeader(jpg)
+	if err != nil || hdr.Progressive {
+		return nil, 0, 0, nil, false
+	}
+	src := image.Pt(hdr.Width, hdr.Height)
+	frame := image.Rectangle{Max: src}
+	if cfg := c.xform.config(); !cfg.identity() {
+		lt, ok := c.xform.lossless()
+		if !ok {
+			return nil, 0, 0, nil, false
+		}
+		out, err := jpegfast.AppendTransform(c.xfBuf[:0], jpg, lt)
+		if err != nil {
+			return nil, 0, 0, nil, false
+		}
+		c.xfBuf, jpg = out, out
+		_, crop, _ := geometry(cfg, src)
+		frame = image.Rectangle{Max: crop.Size()}
+	}
+
+	var regions []image.Rectangle
+	var boxes []detector.Box
+	if draw {
+		boxes = c.xform.mapBoxes(det.Scale(src.X, src.Y), src)
+		regions = c.boxes.dirty(frame, boxes)
+	}
+	fps := c.FPS()
+	if c.osd != nil {
+		regions = append(regions, c.osd.dirty(frame, at, fps))
+	}
+	out, err := jpegfast.AppendPatch(c.encBuf[:0], jpg, regions, func(tile *image.RGBA) {
+		if draw {
+			c.boxes.draw(tile, frame, boxes)
+		}
+		if c.osd != nil {
+			c.osd.draw(tile, frame, at, fps)
+		}
+	})
+	if err != nil {
+		return nil, 0, 0, nil, false
+	}
+	c.encBuf = out
+	return out, frame.Dx(), frame.Dy(), boxes, true
+}
+
 func (c *Camera) detectWorker(ctx context.Context, interval time.Duration, timeout time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	if c.sched != nil {
+		c.scheduledDetect(ctx, ticker)
+		return
+	}
 	for c.run.Load() {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			v := c.detLatest.Load()
-			if v == nil {
+			f := c.detLatest.Load()
+			if f == nil {
 				continue
 			}
-			jpg := v.([]byte)
+			jpg, lb := c.prep.prepare(f)
 			dctx, cancel := context.WithTimeout(ctx, timeout)
-			boxes, err := c.det.DetectJPEGCtx(dctx, jpg, 0.4, 0.45)
+			boxes, err := c.det.Detect(dctx, jpg, detector.DefaultConf, detector.DefaultIOU)
 			cancel()
 			if err != nil {
 				// keep last boxes; don't log every time to avoid spam
 				continue
 			}
-			c.lastBoxesMu.Lock()
-			c.lastBoxes = boxes
-			c.lastAt = time.Now()
-			c.lastBoxesMu.Unlock()
+			c.setDetections(lb.result(boxes), f.jpg)
+		}
+	}
+}
+
+// scheduledDetect hands new frames to the shared scheduler instead of
+// calling the detector itself; results arrive through the callback.
+func (c *Camera) scheduledDetect(ctx context.Context, ticker *time.Ticker) {
+	submit, unregister := c.sched.Register()
+	defer unregister()
+	var last *detFrame
+	for c.run.Load() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// only frames the scheduler has not seen yet
+			if f := c.detLatest.Load(); f != nil && f != last {
+				last = f
+				jpg, lb := c.prep.prepare(f)
+				submit(jpg, func(boxes []detector.Box, err error) {
+					if err == nil {
+						c.setDetections(lb.result(boxes), f.jpg)
+					}
+				})
+			}
 		}
 	}
 }
 
-func (c *Camera) getFreshBoxes(maxAge time.Duration) ([]detector.Box, bool) {
+// detFrame is a camera frame queued for detection.
+type detFrame struct {
+	jpg []byte
+	seq uint64 // the sequence number it is published under
+}
+
+// info describes f for a detection result. The size is read from the JPEG
+// header, which is cheap next to the detection itself.
+func (f *detFrame) info() detector.Frame {
+	size, _ := jpegSize(f.jpg)
+	return detector.Frame{Width: size.X, Height: size.Y, Seq: f.seq}
+}
+
+// setDetections stores a new result for jpg, filtered for everything but
+// privacy and training samples.
+func (c *Camera) setDetections(res detector.Result, jpg []byte) {
+	filtered := c.filter.apply(res)
+	now := time.Now()
+	c.lastBoxesMu.Lock()
+	c.lastDet = filtered
+	c.lastAll = res
+	c.lastDetJPG = jpg
+	c.lastAt = now
+	c.lastBoxesMu.Unlock()
+	if c.smooth != nil {
+		c.smooth.update(filtered, now)
+	}
+	c.autoCapture(jpg, res, now)
+}
+
+// tamperWorker periodically decodes the latest frame and feeds the scene-health analyzer.
+func (c *Camera) tamperWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for c.run.Load() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f := c.detLatest.Load()
+			if f == nil {
+				continue
+			}
+			img, err := decodeScaled(f.jpg, tamperThumbWidth, true)
+			if err != nil {
+				continue
+			}
+			// Keep the published frame as evidence so privacy masks also apply to event snapshots.
+			for _, kind := range c.tamper.analyze(lumaThumb(img, tamperThumbWidth), c.LatestJPEG(), time.Now()) {
+				log.Printf("[%s] tamper: %s", c.id, kind)
+			}
+		}
+	}
+}
+
+// decodeScaled decodes jpg at the smallest DCT scale that is still at least
+// minW wide, luma only when gray is set. Files jpegfast cannot handle
+// (progressive, CMYK) fall back to a full image/jpeg decode.
+func decodeScaled(jpg []byte, minW int, gray bool) (image.Image, error) {
+	if hdr, err := jpegfast.DecodeHeader(jpg); err == nil {
+		opts := jpegfast.DecodeOptions{Scale: jpegfast.ScaleFor(hdr.Width, minW), Gray: gray}
+		if img, err := jpegfast.Decode(jpg, opts); err == nil {
+			return img, nil
+		}
+	}
+	return jpeg.Decode(bytes.NewReader(jpg))
+}
+
+// getFreshBoxes returns the latest detection result if it has boxes and is
+// no older than maxAge, or the smoothed boxes for now if smoothing is on.
+// Results are never modified once stored, so the caller may keep it; Scale
+// gives the boxes for the frame being drawn.
+func (c *Camera) getFreshBoxes(maxAge time.Duration) (detector.Result, bool) {
+	if c.smooth != nil {
+		return c.smooth.boxes(time.Now())
+	}
 	c.lastBoxesMu.RLock()
 	defer c.lastBoxesMu.RUnlock()
-	if len(c.lastBoxes) == 0 {
-		return nil, false
+	if len(c.lastDet.Boxes) == 0 {
+		return detector.Result{}, false
 	}
 	if time.Since(c.lastAt) > maxAge {
-		return nil, false
+		return detector.Result{}, false
 	}
-	// copy slice header (boxes are small structs)
-	out := make([]detector.Box, len(c.lastBoxes))
-	copy(out, c.lastBoxes)
-	return out, true
+	return c.lastDet, true
+}
+
+// lastDetections returns the most recent detection result regardless of age,
+// and when it was produced (zero if the detector never answered).
+func (c *Camera) lastDetections() (detector.Result, time.Time) {
+	c.lastBoxesMu.RLock()
+	defer c.lastBoxesMu.RUnlock()
+	return c.lastDet, c.lastAt
+}
+
+// allDetections is lastDetections without the class filter.
+func (c *Camera) allDetections() (detector.Result, time.Time) {
+	c.lastBoxesMu.RLock()
+	defer c.lastBoxesMu.RUnlock()
+	return c.lastAll, c.lastAt
 }
 
-func (c *Camera) publish(jpg []byte, w, h int) {
+// publish makes jpg the latest frame and raw, the camera's frame it was made
+// from, the latest unannotated one. With metadata enabled jpg is replaced by
+// a copy carrying the camera ID, capture time, sequence number and the
+// detections drawn on it; jpg itself is never modified.
+func (c *Camera) publish(jpg, raw []byte, w, h int, at time.Time, drawn []detector.Box) {
+	if c.opts.Metadata {
+		// only the frame loop publishes, so the next sequence number is ours
+		meta := FrameMeta{Camera: c.id, Time: at, Seq: c.notif.Seq() + 1, Boxes: drawn}
+		if out, err := appendFrameMeta(nil, jpg, meta); err == nil {
+			jpg = out
+		}
+	}
 	c.mu.Lock()
 	c.latest = jpg
+	c.latestRaw = raw
+	c.latestSeq = c.notif.Seq() + 1
 	if w > 0 && h > 0 {
 		c.szW, c.szH = w, h
 	}
+	now := time.Now()
+	if !c.lastPub.IsZero() {
+		if dt := now.Sub(c.lastPub).Seconds(); dt > 0 {
+			if c.fps == 0 {
+				c.fps = 1 / dt
+			} else {
+				c.fps = 0.9*c.fps + 0.1/dt
+			}
+		}
+	}
+	c.lastPub = now
 	c.mu.Unlock()
 	c.notif.next()
 }
@@ -200,5 +502,110 @@ func (c *Camera) LatestJPEG() []byte {
 	return c.latest
 }
 
+// latestFrame returns the latest frame, or the camera's original of it when
+// raw is set, together with its sequence number.
+func (c *Camera) latestFrame(raw bool) ([]byte, uint64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if raw {
+		return c.latestRaw, c.latestSeq
+	}
+	return c.latest, c.latestSeq
+}
+
+// Transform returns the current image transform.
+func (c *Camera) Transform() TransformConfig { return c.xform.config() }
+
+// SetTransform replaces the image transform; it takes effect on the next frame.
+func (c *Camera) SetTransform(t TransformConfig) error { return c.xform.set(t) }
+
+// FPS returns the smoothed rate at which frames are being published.
+func (c *Camera) FPS() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if time.Since(c.lastPub) > 2*time.Second {
+		return 0
+	}
+	return c.fps
+}
+
+// Name returns the configured display name, or the ID when none is set.
+func (c *Camera) Name() string {
+	if c.opts.Name != "" {
+		return c.opts.Name
+	}
+	return c.id
+}
+
 func (c *Camera) Seq() uint64                           { return c.notif.Seq() }
 func (c *Camera) WaitNext(since uint64) <-chan struct{} { return c.notif.WaitNext(since) }
+
+// Status is a point-in-time summary of a camera for the status API.
+type Status struct {
+	ID        string        `json:"id"`
+	Name      string        `json:"name"`
+	URL       string        `json:"url"`
+	Running   bool          `json:"running"`
+	Seq       uint64        `json:"seq"`
+	Width     int           `json:"width,omitempty"`
+	Height    int           `json:"height,omitempty"`
+	LastFrame time.Time     `json:"last_frame"`
+	FPS       float64       `json:"fps"`
+	Tamper    *TamperStatus `json:"tamper,omitempty"`
+	Stream    *StreamStatus `json:"stream,omitempty"`
+	// Detection is the latest detector result, nil until the first one.
+	Detection *DetectionStatus `json:"detection,omitempty"`
+}
+
+// DetectionStatus is a detection result with every box also given relative
+// to the frame size, for clients that draw on frames of another size.
+type DetectionStatus struct {
+	detector.Result
+	At time.Time `json:"at"`
+}
+
+func (c *Camera) Status() Status {
+	c.mu.RLock()
+	st := Status{
+		ID:        c.id,
+		Name:      c.Name(),
+		URL:       c.url,
+		Running:   c.run.Load(),
+		Seq:       c.notif.Seq(),
+		Width:     c.szW,
+		Height:    c.szH,
+		LastFrame: c.lastPub,
+	}
+	c.mu.RUnlock()
+	st.FPS = c.FPS()
+	if c.tamper != nil {
+		ts := c.tamper.status()
+		st.Tamper = &ts
+	}
+	if s := c.stream.Load(); s != nil {
+		ss := s.Status()
+		st.Stream = &ss
+	}
+	if res, at := c.lastDetections(); !at.IsZero() {
+		st.Detection = &DetectionStatus{Result: res.Normalized(), At: at}
+	}
+	return st
+}
+
+// TamperSnapshot returns the frame captured when tamper event id was raised.
+func (c *Camera) TamperSnapshot(id uint64) []byte {
+	if c.tamper == nil {
+		return nil
+	}
+	return c.tamper.snapshot(id)
+}
+
+// ResetTamper re-learns the reference scene, e.g. after a camera was re-aimed on purpose.
+// It reports false when tamper detection is disabled for this camera.
+func (c *Camera) ResetTamper() bool {
+	if c.tamper == nil {
+		return false
+	}
+	c.tamper.reset(time.Now())
+	return true
+}
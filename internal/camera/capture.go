@@ -0,0 +1,133 @@
+package camera
+
+import (
+	"errors"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"Garage48/internal/dataset"
+	"Garage48/internal/detector"
+)
+
+// CaptureConfig samples frames with their detections as training data.
+// Capturing needs a dataset (see UseDataset). The zero value captures only
+// on request.
+type CaptureConfig struct {
+	// Random is the chance that a detection result's frame is saved, 0 to 1.
+	Random float64 `json:"random"`
+	// Frames with a detection of confidence in [LowConfMin, LowConfMax)
+	// are saved: the cases the model is unsure about. LowConfMax 0
+	// disables this.
+	LowConfMin float64 `json:"low_conf_min"`
+	LowConfMax float64 `json:"low_conf_max"`
+	// IntervalMS is the least time between automatic captures, default
+	// 10000, so a busy scene does not flood the dataset.
+	IntervalMS int `json:"interval_ms"`
+}
+
+var (
+	// ErrNoDataset is returned by Capture when no dataset is configured.
+	ErrNoDataset = errors.New("no dataset configured")
+	// ErrNoFrame is returned by Capture before the first detection result.
+	ErrNoFrame = errors.New("no detected frame yet")
+)
+
+// capturer saves detected frames to a dataset.
+type capturer struct {
+	cfg      CaptureConfig
+	interval time.Duration
+	store    *dataset.Store
+
+	mu   sync.Mutex
+	last time.Time // of the last automatic capture
+}
+
+func newCapturer(cfg CaptureConfig, store *dataset.Store) *capturer {
+	interval := time.Duration(cfg.IntervalMS) * time.Millisecond
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return &capturer{cfg: cfg, interval: interval, store: store}
+}
+
+// reason returns why res should be captured automatically, or "".
+func (p *capturer) reason(res detector.Result, now time.Time) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now.Sub(p.last) < p.interval {
+		return ""
+	}
+	why := ""
+	if p.cfg.LowConfMax > 0 {
+		for _, b := range res.Boxes {
+			if b.Conf >= p.cfg.LowConfMin && b.Conf < p.cfg.LowConfMax {
+				why = dataset.ReasonLowConf
+				break
+			}
+		}
+	}
+	if why == "" && p.cfg.Random > 0 && rand.Float64() < p.cfg.Random {
+		why = dataset.ReasonRandom
+	}
+	if why != "" {
+		p.last = now
+	}
+	return why
+}
+
+// UseDataset lets the camera save training samples to store: automatically
+// as configured in Options.Capture, and on Capture. Cameras with privacy
+// masking never save, as samples hold unmasked frames. Call it before
+// Start.
+func (c *Camera) UseDataset(store *dataset.Store) {
+	if c.privacy == nil {
+		c.capture = newCapturer(c.opts.Capture, store)
+	}
+}
+
+// Capture saves the frame of the latest detection result with all of its
+// detections, before the class filter, as a manual sample.
+func (c *Camera) Capture() (dataset.Sample, error) {
+	if c.privacy != nil {
+		return dataset.Sample{}, ErrRawUnavailable
+	}
+	if c.capture == nil {
+		return dataset.Sample{}, ErrNoDataset
+	}
+	c.lastBoxesMu.RLock()
+	jpg, res, at := c.lastDetJPG, c.lastAll, c.lastAt
+	c.lastBoxesMu.RUnlock()
+	if jpg == nil {
+		return dataset.Sample{}, ErrNoFrame
+	}
+	return c.saveSample(jpg, res, at, dataset.ReasonManual)
+}
+
+// autoCapture saves jpg, the frame res was found on, if the capture policy
+// picks it. It runs on the detection path and only logs failures.
+func (c *Camera) autoCapture(jpg []byte, res detector.Result, now time.Time) {
+	if c.capture == nil {
+		return
+	}
+	if why := c.capture.reason(res, now); why != "" {
+		go func() {
+			if _, err := c.saveSample(jpg, res, now, why); err != nil {
+				log.Printf("[%s] capture: %v", c.id, err)
+			}
+		}()
+	}
+}
+
+func (c *Camera) saveSample(jpg []byte, res detector.Result, at time.Time, why string) (dataset.Sample, error) {
+	return c.capture.store.Save(jpg, dataset.Sample{
+		Camera: c.id,
+		Time:   at,
+		Seq:    res.Seq,
+		Reason: why,
+		Width:  res.Width,
+		Height: res.Height,
+		Boxes:  res.Boxes,
+	})
+}
@@ -0,0 +1,102 @@
+package camera
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"Garage48/internal/detector"
+	"Garage48/internal/jpegfast"
+)
+
+// Uploads are padded the way ultralytics letterboxes: to a multiple of the
+// model stride with its grey, so the sidecar has nothing left to resize.
+const letterboxStride = 32
+
+var letterboxColor = color.RGBA{114, 114, 114, 255}
+
+// letterbox records how an upload was made from a camera frame, to map the
+// detector's boxes back.
+type letterbox struct {
+	frame   detector.Frame  // the camera frame
+	canvas  image.Point     // size of the uploaded image
+	content image.Rectangle // where the frame sits on the canvas
+}
+
+// result converts boxes found on the upload to the camera frame's pixels.
+func (lb letterbox) result(boxes []detector.Box) detector.Result {
+	res := detector.NewResult(detector.Frame{Width: lb.canvas.X, Height: lb.canvas.Y}, boxes)
+	if lb.content.Size() == image.Pt(lb.frame.Width, lb.frame.Height) && lb.content.Min == (image.Point{}) {
+		res.Frame = lb.frame
+		return res
+	}
+	for i := range res.Boxes {
+		b := &res.Boxes[i]
+		b.X1, b.X2 = b.X1-lb.content.Min.X, b.X2-lb.content.Min.X
+		b.Y1, b.Y2 = b.Y1-lb.content.Min.Y, b.Y2-lb.content.Min.Y
+	}
+	// clip away the padding, then scale up to the camera frame
+	res = detector.NewResult(detector.Frame{Width: lb.content.Dx(), Height: lb.content.Dy()}, res.Boxes)
+	return detector.NewResult(lb.frame, res.Scale(lb.frame.Width, lb.frame.Height))
+}
+
+// detPrep shrinks frames to the detector's input size before upload. The
+// model resizes to that size anyway, so full-resolution uploads only cost
+// bandwidth and encode time. It is used by the detect worker alone.
+type detPrep struct {
+	size int // Options.DetectSize
+	det  detector.Detector
+	enc  *jpegfast.Encoder
+}
+
+func newDetPrep(size int, det detector.Detector) *detPrep {
+	enc, _ := jpegfast.NewEncoder(jpegfast.EncodeConfig{
+		Quality:     85,
+		Subsampling: jpegfast.Subsample420,
+		Workers:     1,
+	})
+	return &detPrep{size: size, det: det, enc: enc}
+}
+
+// target returns the input size to shrink to, 0 for none.
+func (p *detPrep) target() int {
+	if p.size != 0 {
+		return max(p.size, 0)
+	}
+	if s, ok := p.det.(detector.InputSizer); ok {
+		return s.InputSize()
+	}
+	return 0
+}
+
+// prepare returns the image to upload for f. Frames that already fit, or
+// that cannot be decoded, are sent as they are.
+func (p *detPrep) prepare(f *detFrame) ([]byte, letterbox) {
+	info := f.info()
+	size := image.Pt(info.Width, info.Height)
+	lb := letterbox{frame: info, canvas: size, content: image.Rectangle{Max: size}}
+	n := p.target()
+	if n <= 0 || size.X == 0 || (size.X <= n && size.Y <= n) {
+		return f.jpg, lb
+	}
+	img, err := variantPixels(f.jpg, Variant{MaxW: n, MaxH: n})
+	if err != nil {
+		return f.jpg, lb
+	}
+	fit := img.Bounds().Size()
+	canvas := image.Pt(roundUp(fit.X, letterboxStride), roundUp(fit.Y, letterboxStride))
+	content := image.Rectangle{Max: fit}.Add(canvas.Sub(fit).Div(2))
+	if canvas != fit {
+		padded := image.NewRGBA(image.Rectangle{Max: canvas})
+		draw.Draw(padded, padded.Bounds(), image.NewUniform(letterboxColor), image.Point{}, draw.Src)
+		draw.Draw(padded, content, img, img.Bounds().Min, draw.Src)
+		img = padded
+	}
+	jpg, err := p.enc.AppendEncode(nil, img)
+	if err != nil {
+		return f.jpg, lb
+	}
+	return jpg, letterbox{frame: info, canvas: canvas, content: content}
+}
+
+func roundUp(v, m int) int { return (v + m - 1) / m * m }
@@ -0,0 +1,125 @@
+package camera
+
+import (
+	"context"
+	"image"
+	"testing"
+
+	"Garage48/internal/detector"
+	"Garage48/internal/jpegfast"
+)
+
+func TestLetterboxResult(t *testing.T) {
+	box := func(x1, y1, x2, y2 int) detector.Box {
+		return detector.Box{Label: "car", X1: x1, Y1: y1, X2: x2, Y2: y2}
+	}
+	tests := []struct {
+		name string
+		lb   letterbox
+		in   detector.Box // on the upload
+		want detector.Box // on the camera frame
+	}{
+		{
+			name: "sent as is",
+			lb:   letterbox{frame: detector.Frame{Width: 640, Height: 480}, canvas: image.Pt(640, 480), content: image.Rect(0, 0, 640, 480)},
+			in:   box(10, 20, 110, 220),
+			want: box(10, 20, 110, 220),
+		},
+		{
+			name: "landscape padded top and bottom",
+			lb:   letterbox{frame: detector.Frame{Width: 1920, Height: 1080}, canvas: image.Pt(640, 384), content: image.Rect(0, 12, 640, 372)},
+			in:   box(100, 62, 300, 162),
+			want: box(300, 150, 900, 450),
+		},
+		{
+			name: "portrait padded left and right",
+			lb:   letterbox{frame: detector.Frame{Width: 1080, Height: 1920}, canvas: image.Pt(384, 640), content: image.Rect(12, 0, 372, 640)},
+			in:   box(12, 0, 372, 640),
+			want: box(0, 0, 1080, 1920),
+		},
+		{
+			name: "box reaching into the padding is clipped",
+			lb:   letterbox{frame: detector.Frame{Width: 1920, Height: 1080}, canvas: image.Pt(640, 384), content: image.Rect(0, 12, 640, 372)},
+			in:   box(600, 0, 640, 384),
+			want: box(1800, 0, 1920, 1080),
+		},
+		{
+			name: "odd aspect with an odd offset",
+			lb:   letterbox{frame: detector.Frame{Width: 1001, Height: 333}, canvas: image.Pt(640, 224), content: image.Rect(0, 5, 640, 218)},
+			in:   box(320, 5, 640, 218),
+			want: box(500, 0, 1001, 333),
+		},
+	}
+	for _, tt := range tests {
+		res := tt.lb.result([]detector.Box{tt.in})
+		if res.Frame != tt.lb.frame {
+			t.Errorf("%s: frame %+v, want %+v", tt.name, res.Frame, tt.lb.frame)
+		}
+		if len(res.Boxes) != 1 {
+			t.Fatalf("%s: got %d boxes", tt.name, len(res.Boxes))
+		}
+		got := res.Boxes[0]
+		got.Norm = nil
+		if got != tt.want {
+			t.Errorf("%s: got %d,%d-%d,%d, want %d,%d-%d,%d", tt.name,
+				got.X1, got.Y1, got.X2, got.Y2, tt.want.X1, tt.want.Y1, tt.want.X2, tt.want.Y2)
+		}
+	}
+}
+
+// sizedDetector reports a model input size.
+type sizedDetector struct{ size int }
+
+func (sizedDetector) Detect(context.Context, []byte, float64, float64) ([]detector.Box, error) {
+	return nil, nil
+}
+func (d sizedDetector) InputSize() int { return d.size }
+
+func TestDetPrepPrepare(t *testing.T) {
+	frame := func(w, h int) []byte {
+		enc, err := jpegfast.NewEncoder(jpegfast.EncodeConfig{Quality: 80, Subsampling: jpegfast.Subsample420})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := enc.AppendEncode(nil, image.NewRGBA(image.Rect(0, 0, w, h)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	tests := []struct {
+		name    string
+		size    int // Options.DetectSize
+		model   int // the detector's input size
+		w, h    int
+		canvas  image.Point
+		content image.Rectangle
+	}{
+		{"landscape", 0, 640, 1920, 1080, image.Pt(640, 384), image.Rect(0, 12, 640, 372)},
+		{"portrait", 0, 640, 1080, 1920, image.Pt(384, 640), image.Rect(12, 0, 372, 640)},
+		{"size overrides the model", 320, 640, 1920, 1080, image.Pt(320, 192), image.Rect(0, 6, 320, 186)},
+		{"already fits", 0, 640, 640, 360, image.Pt(640, 360), image.Rect(0, 0, 640, 360)},
+		{"disabled", -1, 640, 1920, 1080, image.Pt(1920, 1080), image.Rect(0, 0, 1920, 1080)},
+		{"model size unknown", 0, 0, 1920, 1080, image.Pt(1920, 1080), image.Rect(0, 0, 1920, 1080)},
+	}
+	for _, tt := range tests {
+		src := frame(tt.w, tt.h)
+		jpg, lb := newDetPrep(tt.size, sizedDetector{tt.model}).prepare(&detFrame{jpg: src, seq: 7})
+		if lb.canvas != tt.canvas || lb.content != tt.content {
+			t.Errorf("%s: canvas %v content %v, want %v %v", tt.name, lb.canvas, lb.content, tt.canvas, tt.content)
+		}
+		if lb.frame != (detector.Frame{Width: tt.w, Height: tt.h, Seq: 7}) {
+			t.Errorf("%s: frame %+v", tt.name, lb.frame)
+		}
+		hdr, err := jpegfast.DecodeHeader(jpg)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if image.Pt(hdr.Width, hdr.Height) != tt.canvas {
+			t.Errorf("%s: uploaded %dx%d, want %v", tt.name, hdr.Width, hdr.Height, tt.canvas)
+		}
+		if tt.canvas == image.Pt(tt.w, tt.h) && &jpg[0] != &src[0] {
+			t.Errorf("%s: frame re-encoded though it fits", tt.name)
+		}
+	}
+}
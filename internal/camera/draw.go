@@ -1,36 +1,205 @@
 package camera
 
 import (
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
+	"strconv"
+	"strings"
+
+	"Garage48/internal/detector"
 )
 
-// drawBoxes draws rectangle outlines over an RGBA image.
-func drawBoxes(dst *image.RGBA, rects []image.Rectangle, col color.RGBA) {
+// BoxStyle configures how detections are rendered onto frames.
+type BoxStyle struct {
+	Thickness  int               `json:"thickness"`   // outline width in pixels (default 2)
+	Palette    map[string]string `json:"palette"`     // label -> "#rrggbb"; other labels use the built-in palette by class ID
+	FontScale  int               `json:"font_scale"`  // label text scale; 0 picks one from the frame height
+	HideLabels bool              `json:"hide_labels"` // draw outlines only
+}
+
+// defaultPalette holds distinct, saturated colors indexed by class ID.
+var defaultPalette = [...]color.RGBA{
+	{0, 255, 0, 255}, {255, 56, 56, 255}, {255, 157, 151, 255}, {255, 112, 31, 255},
+	{255, 178, 29, 255}, {207, 210, 49, 255}, {72, 249, 10, 255}, {146, 204, 23, 255},
+	{61, 219, 134, 255}, {26, 147, 52, 255}, {0, 212, 187, 255}, {44, 153, 168, 255},
+	{0, 194, 255, 255}, {52, 69, 147, 255}, {100, 115, 255, 255}, {0, 24, 236, 255},
+	{132, 56, 255, 255}, {82, 0, 133, 255}, {203, 56, 255, 255}, {255, 149, 200, 255},
+}
+
+// boxRenderer draws detections with a resolved BoxStyle.
+type boxRenderer struct {
+	thickness int
+	fontScale int
+	labels    bool
+	palette   map[string]color.RGBA
+}
+
+func newBoxRenderer(st BoxStyle) *boxRenderer {
+	r := &boxRenderer{
+		thickness: st.Thickness,
+		fontScale: st.FontScale,
+		labels:    !st.HideLabels,
+		palette:   make(map[string]color.RGBA, len(st.Palette)),
+	}
+	if r.thickness <= 0 {
+		r.thickness = 2
+	}
+	for label, hex := range st.Palette {
+		if col, err := parseHexColor(hex); err == nil {
+			r.palette[label] = col
+		}
+	}
+	return r
+}
+
+func (r *boxRenderer) color(b detector.Box) color.RGBA {
+	if col, ok := r.palette[b.Label]; ok {
+		return col
+	}
+	id := b.ClassID
+	if id < 0 {
+		id = -id
+	}
+	return defaultPalette[id%len(defaultPalette)]
+}
+
+// scaleFor picks a font scale that keeps labels readable on large frames.
+func (r *boxRenderer) scaleFor(frameH int) int {
+	if r.fontScale > 0 {
+		return r.fontScale
+	}
+	return max(1, frameH/360)
+}
+
+func boxCaption(b detector.Box) string {
+	s := fmt.Sprintf("%s %d%%", b.Label, int(b.Conf*100+0.5))
+	if b.TrackID > 0 {
+		s += fmt.Sprintf(" #%d", b.TrackID)
+	}
+	return s
+}
+
+// draw renders outlines and label chips for boxes. frame is the full frame
+// rectangle used for chip placement; dst may cover only part of it.
+func (r *boxRenderer) draw(dst *image.RGBA, frame image.Rectangle, boxes []detector.Box) {
+	scale := r.scaleFor(frame.Dy())
+	for _, b := range boxes {
+		col := r.color(b)
+		rect := image.Rect(b.X1, b.Y1, b.X2, b.Y2)
+		drawBoxes(dst, []image.Rectangle{rect}, col, r.thickness)
+		if !r.labels {
+			continue
+		}
+		chip, text := r.chipRect(frame, rect, boxCaption(b), scale)
+		if !chip.Overlaps(dst.Bounds()) {
+			continue
+		}
+		fillRect(dst, chip, col)
+		drawText(dst, text.X, text.Y, boxCaption(b), scale, contrastColor(col))
+	}
+}
+
+// dirty returns the regions draw may modify for boxes, so callers that only
+// re-encode touched areas know what to update.
+func (r *boxRenderer) dirty(frame image.Rectangle, boxes []detector.Box) []image.Rectangle {
+	scale := r.scaleFor(frame.Dy())
+	t := r.thickness
+	out := make([]image.Rectangle, 0, len(boxes)*5)
+	for _, b := range boxes {
+		rect := image.Rect(b.X1, b.Y1, b.X2, b.Y2).Canon()
+		out = append(out,
+			image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, rect.Min.Y+t),
+			image.Rect(rect.Min.X, rect.Max.Y-t, rect.Max.X, rect.Max.Y),
+			image.Rect(rect.Min.X, rect.Min.Y, rect.Min.X+t, rect.Max.Y),
+			image.Rect(rect.Max.X-t, rect.Min.Y, rect.Max.X, rect.Max.Y),
+		)
+		if r.labels {
+			chip, _ := r.chipRect(frame, rect, boxCaption(b), scale)
+			out = append(out, chip)
+		}
+	}
+	return out
+}
+
+// chipRect places the label chip above the box, or just inside its top edge
+// when there is no room, and returns the chip and text origin.
+func (r *boxRenderer) chipRect(frame, box image.Rectangle, caption string, scale int) (image.Rectangle, image.Point) {
+	ts := textSize(caption, scale)
+	pad := scale + 1
+	w, h := ts.X+2*pad, ts.Y+2*pad
+	x := box.Min.X
+	y := box.Min.Y - h
+	if y < frame.Min.Y {
+		y = box.Min.Y
+	}
+	if x+w > frame.Max.X {
+		x = frame.Max.X - w
+	}
+	if x < frame.Min.X {
+		x = frame.Min.X
+	}
+	return image.Rect(x, y, x+w, y+h), image.Pt(x+pad, y+pad)
+}
+
+// contrastColor picks black or white text for a background color.
+func contrastColor(bg color.RGBA) color.RGBA {
+	if 299*int(bg.R)+587*int(bg.G)+114*int(bg.B) > 140000 {
+		return color.RGBA{0, 0, 0, 255}
+	}
+	return color.RGBA{255, 255, 255, 255}
+}
+
+// drawBoxes draws rectangle outlines of the given thickness, growing inwards.
+func drawBoxes(dst *image.RGBA, rects []image.Rectangle, col color.RGBA, thickness int) {
+	t := max(thickness, 1)
 	for _, r := range rects {
+		r = r.Canon()
+		fillRect(dst, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+t), col)
+		fillRect(dst, image.Rect(r.Min.X, r.Max.Y-t, r.Max.X, r.Max.Y), col)
+		fillRect(dst, image.Rect(r.Min.X, r.Min.Y+t, r.Min.X+t, r.Max.Y-t), col)
+		fillRect(dst, image.Rect(r.Max.X-t, r.Min.Y+t, r.Max.X, r.Max.Y-t), col)
+	}
+}
+
+// fillRect paints r (clipped to dst) with an opaque color.
+func fillRect(dst *image.RGBA, r image.Rectangle, col color.RGBA) {
+	r = r.Intersect(dst.Bounds())
+	if r.Empty() {
+		return
+	}
+	row := dst.PixOffset(r.Min.X, r.Min.Y)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		o := row
 		for x := r.Min.X; x < r.Max.X; x++ {
-			if inside(dst, x, r.Min.Y) {
-				dst.SetRGBA(x, r.Min.Y, col)
-			}
-			if inside(dst, x, r.Max.Y-1) {
-				dst.SetRGBA(x, r.Max.Y-1, col)
-			}
-		}
-		for y := r.Min.Y; y < r.Max.Y; y++ {
-			if inside(dst, r.Min.X, y) {
-				dst.SetRGBA(r.Min.X, y, col)
-			}
-			if inside(dst, r.Max.X-1, y) {
-				dst.SetRGBA(r.Max.X-1, y, col)
-			}
+			dst.Pix[o+0] = col.R
+			dst.Pix[o+1] = col.G
+			dst.Pix[o+2] = col.B
+			dst.Pix[o+3] = 255
+			o += 4
 		}
+		row += dst.Stride
 	}
 }
 
-func inside(img *image.RGBA, x, y int) bool {
-	b := img.Bounds()
-	return x >= b.Min.X && x < b.Max.X && y >= b.Min.Y && y < b.Max.Y
+// parseHexColor parses "#rgb" or "#rrggbb". An empty string is opaque black.
+func parseHexColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if s == "" {
+		return color.RGBA{0, 0, 0, 255}, nil
+	}
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
 }
 
 func toRGBA(src image.Image) *image.RGBA {
@@ -0,0 +1,121 @@
+package camera
+
+import (
+	"fmt"
+
+	"Garage48/internal/detector"
+)
+
+// FilterConfig narrows a camera's detections to the classes and shapes that
+// matter for it. Drawing, metadata, status and everything downstream see
+// the filtered set; privacy masking alone works on every detection, so a
+// denied label can never unmask someone. The zero value keeps everything.
+type FilterConfig struct {
+	Allow []string `json:"allow"` // labels to keep; empty keeps all
+	Deny  []string `json:"deny"`  // labels to drop, even if allowed
+	// MinConf is the minimum confidence per label; "*" applies to labels
+	// without an entry of their own.
+	MinConf map[string]float64 `json:"min_conf"`
+	// MinArea and MaxArea bound the box area as a fraction of the frame,
+	// 0 to 1; 0 disables either bound.
+	MinArea float64 `json:"min_area"`
+	MaxArea float64 `json:"max_area"`
+	// MinAspect and MaxAspect bound width/height; 0 disables either bound.
+	MinAspect float64 `json:"min_aspect"`
+	MaxAspect float64 `json:"max_aspect"`
+}
+
+// Validate checks that the bounds are in range and consistent.
+func (f FilterConfig) Validate() error {
+	for label, v := range f.MinConf {
+		if v < 0 || v > 1 {
+			return fmt.Errorf("filter min_conf for %q must be between 0 and 1, got %g", label, v)
+		}
+	}
+	if f.MinArea < 0 || f.MinArea > 1 || f.MaxArea < 0 || f.MaxArea > 1 {
+		return fmt.Errorf("filter areas must be between 0 and 1")
+	}
+	if f.MaxArea > 0 && f.MinArea > f.MaxArea {
+		return fmt.Errorf("filter min_area %g exceeds max_area %g", f.MinArea, f.MaxArea)
+	}
+	if f.MinAspect < 0 || f.MaxAspect < 0 {
+		return fmt.Errorf("filter aspect ratios must not be negative")
+	}
+	if f.MaxAspect > 0 && f.MinAspect > f.MaxAspect {
+		return fmt.Errorf("filter min_aspect %g exceeds max_aspect %g", f.MinAspect, f.MaxAspect)
+	}
+	return nil
+}
+
+func (f FilterConfig) enabled() bool {
+	return len(f.Allow) > 0 || len(f.Deny) > 0 || len(f.MinConf) > 0 ||
+		f.MinArea > 0 || f.MaxArea > 0 || f.MinAspect > 0 || f.MaxAspect > 0
+}
+
+// boxFilter applies a FilterConfig. A nil *boxFilter keeps everything.
+type boxFilter struct {
+	cfg   FilterConfig
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+func newBoxFilter(cfg FilterConfig) *boxFilter {
+	if !cfg.enabled() {
+		return nil
+	}
+	f := &boxFilter{cfg: cfg, deny: make(map[string]bool)}
+	if len(cfg.Allow) > 0 {
+		f.allow = make(map[string]bool)
+		for _, l := range cfg.Allow {
+			f.allow[l] = true
+		}
+	}
+	for _, l := range cfg.Deny {
+		f.deny[l] = true
+	}
+	return f
+}
+
+// apply returns res without the boxes f rejects. Area bounds need the
+// frame size and are skipped when res does not know it.
+func (f *boxFilter) apply(res detector.Result) detector.Result {
+	if f == nil || len(res.Boxes) == 0 {
+		return res
+	}
+	out := res
+	out.Boxes = make([]detector.Box, 0, len(res.Boxes))
+	for _, b := range res.Boxes {
+		if f.keep(b, res.Width, res.Height) {
+			out.Boxes = append(out.Boxes, b)
+		}
+	}
+	return out
+}
+
+func (f *boxFilter) keep(b detector.Box, frameW, frameH int) bool {
+	if (f.allow != nil && !f.allow[b.Label]) || f.deny[b.Label] {
+		return false
+	}
+	if minConf, ok := f.cfg.MinConf[b.Label]; ok {
+		if b.Conf < minConf {
+			return false
+		}
+	} else if b.Conf < f.cfg.MinConf["*"] {
+		return false
+	}
+	w, h := float64(b.X2-b.X1), float64(b.Y2-b.Y1)
+	if w <= 0 || h <= 0 {
+		return false
+	}
+	if frameW > 0 && frameH > 0 {
+		area := w * h / (float64(frameW) * float64(frameH))
+		if area < f.cfg.MinArea || f.cfg.MaxArea > 0 && area > f.cfg.MaxArea {
+			return false
+		}
+	}
+	aspect := w / h
+	if aspect < f.cfg.MinAspect || f.cfg.MaxAspect > 0 && aspect > f.cfg.MaxAspect {
+		return false
+	}
+	return true
+}
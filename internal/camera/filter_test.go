@@ -0,0 +1,120 @@
+package camera
+
+import (
+	"fmt"
+	"testing"
+
+	"Garage48/internal/detector"
+)
+
+func TestBoxFilter(t *testing.T) {
+	frame := detector.Frame{Width: 1000, Height: 1000}
+	// boxes by name: label, confidence and size on the 1000×1000 frame
+	boxes := map[string]detector.Box{
+		"person": {Label: "person", Conf: 0.9, X1: 100, Y1: 100, X2: 200, Y2: 400}, // 3% area, aspect 1/3
+		"faint":  {Label: "person", Conf: 0.3, X1: 100, Y1: 100, X2: 200, Y2: 400},
+		"car":    {Label: "car", Conf: 0.6, X1: 0, Y1: 0, X2: 400, Y2: 200},   // 8%, aspect 2
+		"cat":    {Label: "cat", Conf: 0.5, X1: 0, Y1: 0, X2: 30, Y2: 30},     // 0.09%, aspect 1
+		"truck":  {Label: "truck", Conf: 0.8, X1: 0, Y1: 0, X2: 900, Y2: 900}, // 81%
+		"empty":  {Label: "car", Conf: 0.9, X1: 50, Y1: 50, X2: 50, Y2: 90},
+	}
+	all := []string{"person", "faint", "car", "cat", "truck", "empty"}
+	tests := []struct {
+		name string
+		cfg  FilterConfig
+		want []string
+	}{
+		{"zero value keeps all", FilterConfig{}, all},
+		{"allow", FilterConfig{Allow: []string{"person", "car"}}, []string{"person", "faint", "car", "empty"}},
+		{"deny", FilterConfig{Deny: []string{"car"}}, []string{"person", "faint", "cat", "truck"}},
+		{"deny wins over allow", FilterConfig{Allow: []string{"person", "car"}, Deny: []string{"car"}}, []string{"person", "faint"}},
+		{"min conf per label", FilterConfig{MinConf: map[string]float64{"person": 0.5}}, []string{"person", "car", "cat", "truck"}},
+		{"min conf default", FilterConfig{MinConf: map[string]float64{"*": 0.55}}, []string{"person", "car", "truck"}},
+		{"label entry beats default", FilterConfig{MinConf: map[string]float64{"*": 0.7, "cat": 0.1}}, []string{"person", "cat", "truck"}},
+		{"min area", FilterConfig{MinArea: 0.01}, []string{"person", "faint", "car", "truck"}},
+		{"max area", FilterConfig{MaxArea: 0.5}, []string{"person", "faint", "car", "cat"}},
+		{"area band", FilterConfig{MinArea: 0.05, MaxArea: 0.5}, []string{"car"}},
+		{"min aspect", FilterConfig{MinAspect: 1}, []string{"car", "cat", "truck"}},
+		{"max aspect", FilterConfig{MaxAspect: 0.5}, []string{"person", "faint"}},
+		{"combined", FilterConfig{Allow: []string{"person", "cat"}, MinConf: map[string]float64{"*": 0.4}, MinArea: 0.01}, []string{"person"}},
+	}
+	for _, tt := range tests {
+		var in []detector.Box
+		for _, name := range all {
+			in = append(in, boxes[name])
+		}
+		res := newBoxFilter(tt.cfg).apply(detector.Result{Frame: frame, Boxes: in})
+		var want []detector.Box
+		for _, name := range tt.want {
+			want = append(want, boxes[name])
+		}
+		// a zero-size box is dropped by any filter but kept without one
+		if tt.cfg.enabled() {
+			want = withoutEmpty(want)
+		}
+		if !equalBoxes(res.Boxes, want) {
+			t.Errorf("%s: got %v, want %v", tt.name, labelsOf(res.Boxes), labelsOf(want))
+		}
+	}
+}
+
+func TestBoxFilterNoFrameSize(t *testing.T) {
+	f := newBoxFilter(FilterConfig{MinArea: 0.5})
+	in := []detector.Box{{Label: "cat", X1: 0, Y1: 0, X2: 10, Y2: 10}}
+	if res := f.apply(detector.Result{Boxes: in}); len(res.Boxes) != 1 {
+		t.Error("area bound applied without a frame size")
+	}
+}
+
+func TestFilterConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  FilterConfig
+		ok   bool
+	}{
+		{"zero", FilterConfig{}, true},
+		{"conf above 1", FilterConfig{MinConf: map[string]float64{"*": 1.2}}, false},
+		{"negative area", FilterConfig{MinArea: -0.1}, false},
+		{"area above 1", FilterConfig{MaxArea: 2}, false},
+		{"crossed areas", FilterConfig{MinArea: 0.5, MaxArea: 0.2}, false},
+		{"min area alone", FilterConfig{MinArea: 0.5}, true},
+		{"negative aspect", FilterConfig{MinAspect: -1}, false},
+		{"crossed aspects", FilterConfig{MinAspect: 3, MaxAspect: 1}, false},
+		{"aspect band", FilterConfig{MinAspect: 0.5, MaxAspect: 2}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+}
+
+func withoutEmpty(boxes []detector.Box) []detector.Box {
+	var out []detector.Box
+	for _, b := range boxes {
+		if b.X2 > b.X1 && b.Y2 > b.Y1 {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+func equalBoxes(a, b []detector.Box) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func labelsOf(boxes []detector.Box) []string {
+	var out []string
+	for _, b := range boxes {
+		out = append(out, fmt.Sprintf("%s@%.1f", b.Label, b.Conf))
+	}
+	return out
+}
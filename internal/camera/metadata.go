@@ -0,0 +1,66 @@
+package camera
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"Garage48/internal/detector"
+	"Garage48/internal/jpegfast"
+)
+
+// Published frames can carry a COM segment with this prefix followed by a
+// FrameMeta as JSON. Any JPEG reader skips it; ReadFrameMeta recovers it.
+const (
+	metaMarker = 0xFE // COM
+	metaPrefix = "Garage48 "
+)
+
+// FrameMeta is the context embedded in a published frame.
+type FrameMeta struct {
+	Camera string    `json:"camera"`
+	Time   time.Time `json:"time"` // when the frame was received
+	Seq    uint64    `json:"seq"`  // as reported by Camera.Seq once published
+	// Boxes are the detections drawn on the frame, in its own pixels.
+	Boxes []detector.Box `json:"boxes"`
+}
+
+// ErrNoFrameMeta is returned by ReadFrameMeta for JPEGs without metadata.
+var ErrNoFrameMeta = errors.New("no frame metadata")
+
+// ReadFrameMeta extracts the metadata embedded in a published frame.
+func ReadFrameMeta(jpg []byte) (FrameMeta, error) {
+	var m FrameMeta
+	raw := jpegfast.FindSegment(jpg, metaMarker, []byte(metaPrefix))
+	if raw == nil {
+		return m, ErrNoFrameMeta
+	}
+	err := json.Unmarshal(raw, &m)
+	return m, err
+}
+
+// appendFrameMeta appends jpg to dst with m embedded. Detections that do not
+// fit in one segment are left out, lowest confidence first.
+func appendFrameMeta(dst, jpg []byte, m FrameMeta) ([]byte, error) {
+	if m.Boxes == nil {
+		m.Boxes = []detector.Box{}
+	}
+	sorted := false
+	for {
+		payload, err := json.Marshal(m)
+		if err != nil {
+			return dst, err
+		}
+		if len(metaPrefix)+len(payload) <= jpegfast.MaxSegmentPayload || len(m.Boxes) == 0 {
+			return jpegfast.InsertSegment(dst, jpg, metaMarker, append([]byte(metaPrefix), payload...))
+		}
+		if !sorted {
+			// sort a copy; the caller's slice is left alone
+			sorted = true
+			m.Boxes = append([]detector.Box(nil), m.Boxes...)
+			sort.SliceStable(m.Boxes, func(i, j int) bool { return m.Boxes[i].Conf > m.Boxes[j].Conf })
+		}
+		m.Boxes = m.Boxes[:len(m.Boxes)/2]
+	}
+}
@@ -9,13 +9,90 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
+
+	"Garage48/internal/jpegfast"
 )
 
 // MJPEGClient consumes a multipart/x-mixed-replace MJPEG stream and yields JPEG frames.
+// Parts that are not a complete JPEG are dropped and counted in Stats.
 type MJPEGClient struct {
 	URL    string
 	Client *http.Client
+	Stats  StreamStats
+}
+
+// StreamStats counts what an MJPEGClient received. It is safe for concurrent use.
+type StreamStats struct {
+	mu sync.Mutex
+	st StreamStatus
+}
+
+// StreamStatus is a snapshot of StreamStats for the status API.
+type StreamStatus struct {
+	Frames     uint64       `json:"frames"`
+	Dropped    uint64       `json:"dropped"`
+	Bytes      uint64       `json:"bytes"`
+	Reconnects uint64       `json:"reconnects"`
+	LastDrop   string       `json:"last_drop,omitempty"`
+	LastDropAt time.Time    `json:"last_drop_at"`
+	Format     *FrameFormat `json:"format,omitempty"` // of the last good frame
+}
+
+// FrameFormat describes the JPEGs a camera sends, as found by jpegfast.Analyze.
+type FrameFormat struct {
+	Width          int    `json:"width"`
+	Height         int    `json:"height"`
+	Sampling       string `json:"sampling"`
+	Progressive    bool   `json:"progressive"`
+	Quality        int    `json:"quality"`
+	StandardTables bool   `json:"standard_tables"`
+	Restart        int    `json:"restart_interval"`
+	Orientation    int    `json:"orientation,omitempty"`
+}
+
+func (s *StreamStats) frame(info jpegfast.Info, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.st.Frames++
+	s.st.Bytes += uint64(n)
+	s.st.Format = &FrameFormat{
+		Width:          info.Width,
+		Height:         info.Height,
+		Sampling:       info.Sampling,
+		Progressive:    info.Progressive,
+		Quality:        info.Quality,
+		StandardTables: info.StandardTables,
+		Restart:        info.Restart,
+		Orientation:    info.Orientation,
+	}
+}
+
+func (s *StreamStats) drop(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.st.Dropped++
+	s.st.LastDrop = reason
+	s.st.LastDropAt = time.Now()
+}
+
+func (s *StreamStats) reconnect() {
+	s.mu.Lock()
+	s.st.Reconnects++
+	s.mu.Unlock()
+}
+
+// Status returns a snapshot of the counters.
+func (s *StreamStats) Status() StreamStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.st
+	if st.Format != nil {
+		f := *st.Format
+		st.Format = &f
+	}
+	return st
 }
 
 // NewMJPEGClient creates a client with sensible timeouts.
@@ -44,10 +121,13 @@ func NewMJPEGClient(url string) *MJPEGClient {
 func (m *MJPEGClient) Stream(ctx context.Context, frames chan<- []byte) error {
 	defer close(frames)
 	backoff := 500 * time.Millisecond
-	for {
+	for attempt := 0; ; attempt++ {
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
+		if attempt > 0 {
+			m.Stats.reconnect()
+		}
 		req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.URL, nil)
 		if err != nil {
 			return fmt.Errorf("mjpeg request: %w", err)
@@ -106,8 +186,17 @@ func (m *MJPEGClient) Stream(ctx context.Context, frames chan<- []byte) error {
 			_ = part.Close()
 			if err != nil {
 				// broken frame; continue to next part
+				m.Stats.drop(err.Error())
+				continue
+			}
+			// cameras on flaky links send cut-off or garbled parts that
+			// would only fail later in the decoder
+			info, err := jpegfast.Analyze(buf)
+			if err != nil {
+				m.Stats.drop(err.Error())
 				continue
 			}
+			m.Stats.frame(info, len(buf))
 			// Deliver frame
 			select {
 			case frames <- buf:
@@ -0,0 +1,240 @@
+package camera
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"math"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"Garage48/internal/jpegfast"
+)
+
+// MosaicSpec selects the cameras and grid of a mosaic stream.
+type MosaicSpec struct {
+	Cams  []string
+	Cols  int // 0 with Rows 0 picks a near-square grid
+	Rows  int
+	Width int // output width in pixels, rounded down to whole MCUs; 0 for 1920
+}
+
+const (
+	mosaicDefaultWidth = 1920
+	mosaicMaxWidth     = 3840
+	mosaicMaxCells     = 36
+	mosaicFPS          = 10
+	mosaicStale        = 5 * time.Second // cameras silent this long show as offline
+)
+
+var (
+	mosaicBG      = color.RGBA{0x10, 0x10, 0x10, 0xFF}
+	mosaicLabelBG = color.RGBA{0, 0, 0, 0xFF}
+	mosaicLabelFG = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	mosaicDimFG   = color.RGBA{0x90, 0x90, 0x90, 0xFF}
+)
+
+// normalize fills in defaults and checks that the grid can hold every camera.
+func (s MosaicSpec) normalize() (MosaicSpec, error) {
+	n := len(s.Cams)
+	if n == 0 {
+		return s, fmt.Errorf("no cameras")
+	}
+	if s.Cols < 0 || s.Rows < 0 || s.Width < 0 {
+		return s, fmt.Errorf("layout and width must not be negative")
+	}
+	if s.Cols == 0 && s.Rows == 0 {
+		s.Cols = int(math.Ceil(math.Sqrt(float64(n))))
+	}
+	if s.Cols == 0 {
+		s.Cols = (n + s.Rows - 1) / s.Rows
+	}
+	if s.Rows == 0 {
+		s.Rows = (n + s.Cols - 1) / s.Cols
+	}
+	if s.Cols*s.Rows > mosaicMaxCells {
+		return s, fmt.Errorf("at most %d cells", mosaicMaxCells)
+	}
+	if s.Cols*s.Rows < n {
+		return s, fmt.Errorf("%dx%d layout cannot hold %d cameras", s.Cols, s.Rows, n)
+	}
+	if s.Width == 0 {
+		s.Width = mosaicDefaultWidth
+	}
+	if s.Width > mosaicMaxWidth || s.Width < 16*s.Cols {
+		return s, fmt.Errorf("width must be between %d and %d", 16*s.Cols, mosaicMaxWidth)
+	}
+	return s, nil
+}
+
+func (s MosaicSpec) key() string {
+	return fmt.Sprintf("%s|%dx%d|%d", strings.Join(s.Cams, ","), s.Cols, s.Rows, s.Width)
+}
+
+// tileSize returns the size of one 16:9 cell, kept to whole 16-pixel MCUs.
+func (s MosaicSpec) tileSize() image.Point {
+	w := s.Width / s.Cols &^ 15
+	return image.Pt(w, max(w*9/16&^15, 16))
+}
+
+// Mosaic composes the latest frames of several cameras into one grid and
+// encodes it once per update for all of its viewers.
+type Mosaic struct {
+	spec  MosaicSpec
+	reg   *Registry
+	notif *notifier
+	stop  chan struct{}
+
+	mu     sync.RWMutex
+	latest []byte
+
+	viewers int // guarded by Registry.mu
+}
+
+// Mosaic returns the running mosaic for spec, starting it if needed, and a
+// release function the viewer must call when it leaves. The mosaic stops
+// with its last viewer.
+func (r *Registry) Mosaic(spec MosaicSpec) (*Mosaic, func(), error) {
+	spec, err := spec.normalize()
+	if err != nil {
+		return nil, nil, err
+	}
+	key := spec.key()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mosaics == nil {
+		r.mosaics = make(map[string]*Mosaic)
+	}
+	m := r.mosaics[key]
+	if m == nil {
+		m = &Mosaic{spec: spec, reg: r, notif: newNotifier(), stop: make(chan struct{})}
+		r.mosaics[key] = m
+		go m.run()
+	}
+	m.viewers++
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if m.viewers--; m.viewers == 0 {
+				close(m.stop)
+				delete(r.mosaics, key)
+			}
+		})
+	}
+	return m, release, nil
+}
+
+func (m *Mosaic) LatestJPEG() []byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.latest
+}
+
+func (m *Mosaic) Seq() uint64                           { return m.notif.Seq() }
+func (m *Mosaic) WaitNext(since uint64) <-chan struct{} { return m.notif.WaitNext(since) }
+
+// mosaicCell remembers what a cell shows so unchanged cells are not redrawn.
+type mosaicCell struct {
+	seq   uint64
+	state string // "" when showing a frame, else the placeholder text
+	drawn bool
+}
+
+func (m *Mosaic) run() {
+	tile := m.spec.tileSize()
+	canvas := image.NewRGBA(image.Rect(0, 0, tile.X*m.spec.Cols, tile.Y*m.spec.Rows))
+	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{mosaicBG}, image.Point{}, draw.Src)
+	enc, _ := jpegfast.NewEncoder(jpegfast.EncodeConfig{
+		Quality:     80,
+		Subsampling: jpegfast.Subsample420,
+		Workers:     runtime.NumCPU(),
+	})
+	cells := make([]mosaicCell, len(m.spec.Cams))
+
+	tk := time.NewTicker(time.Second / mosaicFPS)
+	defer tk.Stop()
+	first := true
+	for {
+		changed := first
+		first = false
+		for i, id := range m.spec.Cams {
+			r := image.Rect(0, 0, tile.X, tile.Y).Add(image.Pt(i%m.spec.Cols*tile.X, i/m.spec.Cols*tile.Y))
+			if m.updateCell(canvas, r, &cells[i], id) {
+				changed = true
+			}
+		}
+		if changed {
+			out, err := enc.AppendEncode(nil, canvas)
+			if err != nil {
+				log.Printf("mosaic encode error: %v", err)
+			} else {
+				m.mu.Lock()
+				m.latest = out
+				m.mu.Unlock()
+				m.notif.next()
+			}
+		}
+		select {
+		case <-m.stop:
+			return
+		case <-tk.C:
+		}
+	}
+}
+
+// updateCell redraws cell r if its camera has a new frame or changed state,
+// reporting whether it did.
+func (m *Mosaic) updateCell(canvas *image.RGBA, r image.Rectangle, cell *mosaicCell, id string) bool {
+	cam := m.reg.Get(id)
+	state, label := "", id
+	var seq uint64
+	if cam == nil {
+		state = "unknown camera"
+	} else {
+		label = cam.Name()
+		cam.mu.RLock()
+		stale := cam.latest == nil || time.Since(cam.lastPub) > mosaicStale
+		cam.mu.RUnlock()
+		if stale {
+			state = "offline"
+		} else {
+			seq = cam.Seq()
+		}
+	}
+	if cell.drawn && state == cell.state && (state != "" || seq == cell.seq) {
+		return false
+	}
+
+	tile := canvas.SubImage(r).(*image.RGBA)
+	draw.Draw(tile, r, &image.Uniform{mosaicBG}, image.Point{}, draw.Src)
+	scale := max(1, r.Dy()/160)
+	if state == "" {
+		img, got, err := cam.VariantImage(Variant{MaxW: r.Dx(), MaxH: r.Dy()})
+		if err != nil || img == nil {
+			state = "no picture"
+		} else {
+			seq = got
+			// letterbox inside the cell
+			b := img.Bounds()
+			at := r.Min.Add(image.Pt((r.Dx()-b.Dx())/2, (r.Dy()-b.Dy())/2))
+			draw.Draw(tile, image.Rectangle{Min: at, Max: at.Add(b.Size())}, img, b.Min, draw.Src)
+		}
+	}
+	if state != "" {
+		ts := textSize(state, 2*scale)
+		drawText(tile, r.Min.X+(r.Dx()-ts.X)/2, r.Min.Y+(r.Dy()-ts.Y)/2, state, 2*scale, mosaicDimFG)
+	}
+	pad := 3 * scale
+	ls := textSize(label, scale)
+	fillRect(tile, image.Rect(r.Min.X, r.Min.Y, r.Min.X+ls.X+2*pad, r.Min.Y+ls.Y+2*pad), mosaicLabelBG)
+	drawText(tile, r.Min.X+pad, r.Min.Y+pad, label, scale, mosaicLabelFG)
+
+	*cell = mosaicCell{seq: seq, state: state, drawn: true}
+	return true
+}
@@ -0,0 +1,35 @@
+package camera
+
+import "Garage48/internal/detector"
+
+// Options holds per-camera processing settings loaded from config.json.
+// The zero value disables every optional stage.
+type Options struct {
+	Name      string          `json:"name"` // display name for overlays and the dashboard
+	Tamper    TamperConfig    `json:"tamper"`
+	Privacy   PrivacyConfig   `json:"privacy"`
+	Boxes     BoxStyle        `json:"boxes"`
+	OSD       OSDConfig       `json:"osd"`
+	Transform TransformConfig `json:"transform"`
+	// Detector picks the detection backend; the zero value is the sidecar
+	// given on the command line.
+	Detector detector.Config `json:"detector"`
+	// DetectSize is the longest side frames are shrunk and letterboxed to
+	// before upload. 0 follows the imgsz the sidecar reports on /health;
+	// negative uploads full frames.
+	DetectSize int `json:"detect_size"`
+	// Filter drops detections of unwanted classes, low confidence or
+	// implausible size and shape.
+	Filter FilterConfig `json:"filter"`
+	// Smooth steadies boxes across detector results against flicker.
+	Smooth SmoothConfig `json:"smooth"`
+	// Capture samples frames as training data when a dataset is set up.
+	Capture CaptureConfig `json:"capture"`
+	// EncodeWorkers is the number of goroutines used to re-encode annotated
+	// frames; 0 uses every CPU, 1 encodes serially.
+	EncodeWorkers int `json:"encode_workers"`
+	// Metadata embeds a COM segment with the camera ID, capture time,
+	// sequence number and drawn detections in every published frame, so
+	// saved snapshots keep their context; see ReadFrameMeta.
+	Metadata bool `json:"metadata"`
+}
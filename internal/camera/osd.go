@@ -0,0 +1,133 @@
+package camera
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"strings"
+	"time"
+)
+
+// OSDConfig configures the on-screen display burned into every published frame.
+// When enabled with no fields selected, the camera name and time are shown.
+type OSDConfig struct {
+	Enabled    bool   `json:"enabled"`
+	Name       bool   `json:"name"`        // camera display name (falls back to the ID)
+	Time       bool   `json:"time"`        // wall-clock capture time
+	FPS        bool   `json:"fps"`         // measured output frame rate
+	Text       string `json:"text"`        // custom text; "\n" starts a new line
+	TimeFormat string `json:"time_format"` // Go time layout (default "2006-01-02 15:04:05 MST")
+	Timezone   string `json:"timezone"`    // IANA zone name (default local time)
+	Position   string `json:"position"`    // "top-left" (default), "top-right", "bottom-left", "bottom-right"
+	Scale      int    `json:"scale"`       // text scale; 0 picks one from the frame height
+	Margin     int    `json:"margin"`      // distance from the frame edge in pixels (default 8)
+	Color      string `json:"color"`       // text color (default white)
+	Background string `json:"background"`  // box color (default black); "none" draws no box
+}
+
+type osdRenderer struct {
+	cfg   OSDConfig
+	title string
+	loc   *time.Location
+	fg    color.RGBA
+	bg    color.RGBA
+	noBG  bool
+}
+
+func newOSDRenderer(cfg OSDConfig, title string) *osdRenderer {
+	if !cfg.Name && !cfg.Time && !cfg.FPS && cfg.Text == "" {
+		cfg.Name, cfg.Time = true, true
+	}
+	if cfg.TimeFormat == "" {
+		cfg.TimeFormat = "2006-01-02 15:04:05 MST"
+	}
+	if cfg.Margin <= 0 {
+		cfg.Margin = 8
+	}
+	o := &osdRenderer{cfg: cfg, title: title, loc: time.Local}
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			log.Printf("osd: unknown timezone %q, using local time: %v", cfg.Timezone, err)
+		} else {
+			o.loc = loc
+		}
+	}
+	var err error
+	if o.fg, err = parseHexColor(cfg.Color); err != nil || cfg.Color == "" {
+		o.fg = color.RGBA{255, 255, 255, 255}
+	}
+	if cfg.Background == "none" {
+		o.noBG = true
+	} else if o.bg, err = parseHexColor(cfg.Background); err != nil {
+		o.bg = color.RGBA{0, 0, 0, 255}
+	}
+	return o
+}
+
+func (o *osdRenderer) lines(at time.Time, fps float64) []string {
+	var out []string
+	if o.cfg.Name {
+		out = append(out, o.title)
+	}
+	if o.cfg.Time {
+		out = append(out, at.In(o.loc).Format(o.cfg.TimeFormat))
+	}
+	if o.cfg.FPS {
+		out = append(out, fmt.Sprintf("%.1f fps", fps))
+	}
+	if o.cfg.Text != "" {
+		out = append(out, strings.Split(o.cfg.Text, "\n")...)
+	}
+	return out
+}
+
+// layout returns the OSD box for a frame and the text lines to draw in it.
+func (o *osdRenderer) layout(frame image.Rectangle, at time.Time, fps float64) (image.Rectangle, []string, int) {
+	scale := o.cfg.Scale
+	if scale <= 0 {
+		scale = max(1, frame.Dy()/360)
+	}
+	lines := o.lines(at, fps)
+	pad := 2 * scale
+	lineH := (glyphH + 2) * scale
+	w := 0
+	for _, l := range lines {
+		w = max(w, textSize(l, scale).X)
+	}
+	w += 2 * pad
+	h := len(lines)*lineH + 2*pad - 2*scale
+
+	m := o.cfg.Margin
+	x, y := frame.Min.X+m, frame.Min.Y+m
+	if strings.HasSuffix(o.cfg.Position, "right") {
+		x = frame.Max.X - m - w
+	}
+	if strings.HasPrefix(o.cfg.Position, "bottom") {
+		y = frame.Max.Y - m - h
+	}
+	return image.Rect(x, y, x+w, y+h), lines, scale
+}
+
+// dirty returns the region draw modifies for the same arguments.
+func (o *osdRenderer) dirty(frame image.Rectangle, at time.Time, fps float64) image.Rectangle {
+	box, _, _ := o.layout(frame, at, fps)
+	return box
+}
+
+// draw renders the OSD block. frame is the full frame rectangle; dst may
+// cover only part of it.
+func (o *osdRenderer) draw(dst *image.RGBA, frame image.Rectangle, at time.Time, fps float64) {
+	box, lines, scale := o.layout(frame, at, fps)
+	if !box.Overlaps(dst.Bounds()) {
+		return
+	}
+	if !o.noBG {
+		fillRect(dst, box, o.bg)
+	}
+	pad := 2 * scale
+	for i, l := range lines {
+		drawText(dst, box.Min.X+pad, box.Min.Y+pad+i*(glyphH+2)*scale, l, scale, o.fg)
+	}
+}
@@ -0,0 +1,278 @@
+package camera
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"Garage48/internal/detector"
+)
+
+// PrivacyConfig configures anonymization of outgoing frames.
+// Static masks are always filled; boxes with one of Labels are pixelated or blurred.
+type PrivacyConfig struct {
+	Masks     []PrivacyMask `json:"masks"`
+	Labels    []string      `json:"labels"`     // detector labels to anonymize, e.g. "person"
+	Mode      string        `json:"mode"`       // "pixelate" (default), "blur" or "fill"
+	BlockSize int           `json:"block_size"` // pixelation cell / blur radius in pixels (default 16)
+	Pad       float64       `json:"pad"`        // fractional growth of each box on every side (default 0.15)
+	HoldMS    int           `json:"hold_ms"`    // keep anonymizing a box after it vanished (default 1000)
+	StaleMS   int           `json:"stale_ms"`   // detection age after which the whole frame is anonymized (default 2000)
+}
+
+// PrivacyMask is a solid polygon. Points are camera-frame pixels before any
+// transform, or fractions of the frame size when Normalized is set.
+type PrivacyMask struct {
+	Points     [][2]float64 `json:"points"`
+	Normalized bool         `json:"normalized"`
+	Color      string       `json:"color"` // "#rrggbb", default black
+}
+
+func (c PrivacyConfig) enabled() bool { return len(c.Masks) > 0 || len(c.Labels) > 0 }
+
+func (c PrivacyConfig) withDefaults() PrivacyConfig {
+	if c.Mode == "" {
+		c.Mode = "pixelate"
+	}
+	if c.BlockSize <= 0 {
+		c.BlockSize = 16
+	}
+	if c.Pad <= 0 {
+		c.Pad = 0.15
+	}
+	if c.HoldMS <= 0 {
+		c.HoldMS = 1000
+	}
+	if c.StaleMS <= 0 {
+		c.StaleMS = 2000
+	}
+	return c
+}
+
+type heldBox struct {
+	r  image.Rectangle
+	at time.Time
+}
+
+// privacyFilter applies masks to decoded frames. Once configured, every
+// published frame must pass through apply; callers drop frames they cannot
+// decode instead of passing them through.
+type privacyFilter struct {
+	cfg    PrivacyConfig
+	labels map[string]bool
+	masks  []PrivacyMask
+	colors []color.RGBA
+
+	mu   sync.Mutex
+	held []heldBox
+}
+
+func newPrivacyFilter(cfg PrivacyConfig) *privacyFilter {
+	cfg = cfg.withDefaults()
+	p := &privacyFilter{cfg: cfg, labels: make(map[string]bool)}
+	for _, l := range cfg.Labels {
+		p.labels[l] = true
+	}
+	for _, m := range cfg.Masks {
+		if len(m.Points) < 3 {
+			continue
+		}
+		col, err := parseHexColor(m.Color)
+		if err != nil {
+			col = color.RGBA{0, 0, 0, 255}
+		}
+		p.masks = append(p.masks, m)
+		p.colors = append(p.colors, col)
+	}
+	return p
+}
+
+// apply anonymizes dst in place. boxes are the latest detections and detAt
+// is when they were produced (zero if never).
+func (p *privacyFilter) apply(dst *image.RGBA, boxes []detector.Box, detAt, now time.Time) {
+	if len(p.labels) > 0 {
+		if detAt.IsZero() || now.Sub(detAt) > time.Duration(p.cfg.StaleMS)*time.Millisecond {
+			// Fail closed: without recent detections we cannot know where people are.
+			p.anonymize(dst, dst.Bounds())
+		} else {
+			for _, r := range p.regions(boxes, detAt, now) {
+				p.anonymize(dst, r)
+			}
+		}
+	}
+	b := dst.Bounds()
+	for i, m := range p.masks {
+		pts := make([]image.Point, len(m.Points))
+		for j, pt := range m.Points {
+			x, y := pt[0], pt[1]
+			if m.Normalized {
+				x *= float64(b.Dx())
+				y *= float64(b.Dy())
+			}
+			pts[j] = image.Pt(b.Min.X+int(math.Round(x)), b.Min.Y+int(math.Round(y)))
+		}
+		fillPolygon(dst, pts, p.colors[i])
+	}
+}
+
+// regions merges fresh boxes of privacy labels into the held set and returns
+// the padded rectangles to anonymize.
+func (p *privacyFilter) regions(boxes []detector.Box, detAt, now time.Time) []image.Rectangle {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, b := range boxes {
+		if !p.labels[b.Label] {
+			continue
+		}
+		r := image.Rect(b.X1, b.Y1, b.X2, b.Y2)
+		px := int(float64(r.Dx()) * p.cfg.Pad)
+		py := int(float64(r.Dy()) * p.cfg.Pad)
+		p.held = append(p.held, heldBox{r: r.Inset(-max(px, py)), at: detAt})
+	}
+	hold := time.Duration(p.cfg.HoldMS) * time.Millisecond
+	kept := p.held[:0]
+	out := make([]image.Rectangle, 0, len(p.held))
+	for _, h := range p.held {
+		if now.Sub(h.at) > hold {
+			continue
+		}
+		// The same detection result is handed to every frame until the next one
+		// arrives; skip exact duplicates so the held list stays small.
+		dup := false
+		for _, k := range kept {
+			if k.r == h.r && k.at.Equal(h.at) {
+				dup = true
+				break
+			}
+		}
+		if dup {
+			continue
+		}
+		kept = append(kept, h)
+		out = append(out, h.r)
+	}
+	p.held = kept
+	return out
+}
+
+func (p *privacyFilter) anonymize(dst *image.RGBA, r image.Rectangle) {
+	r = r.Intersect(dst.Bounds())
+	if r.Empty() {
+		return
+	}
+	switch p.cfg.Mode {
+	case "fill":
+		fillRect(dst, r, color.RGBA{0, 0, 0, 255})
+	case "blur":
+		boxBlur(dst, r, p.cfg.BlockSize)
+	default:
+		pixelate(dst, r, p.cfg.BlockSize)
+	}
+}
+
+// pixelate replaces each cell x cell block inside r with its average color.
+func pixelate(dst *image.RGBA, r image.Rectangle, cell int) {
+	for y0 := r.Min.Y; y0 < r.Max.Y; y0 += cell {
+		y1 := min(y0+cell, r.Max.Y)
+		for x0 := r.Min.X; x0 < r.Max.X; x0 += cell {
+			x1 := min(x0+cell, r.Max.X)
+			var sr, sg, sb, n uint32
+			for y := y0; y < y1; y++ {
+				o := dst.PixOffset(x0, y)
+				for x := x0; x < x1; x++ {
+					sr += uint32(dst.Pix[o])
+					sg += uint32(dst.Pix[o+1])
+					sb += uint32(dst.Pix[o+2])
+					o += 4
+					n++
+				}
+			}
+			fillRect(dst, image.Rect(x0, y0, x1, y1), color.RGBA{uint8(sr / n), uint8(sg / n), uint8(sb / n), 255})
+		}
+	}
+}
+
+// boxBlur applies a separable moving-average blur of the given radius inside r,
+// twice, which is close enough to a Gaussian to hide faces.
+func boxBlur(dst *image.RGBA, r image.Rectangle, radius int) {
+	if radius < 1 {
+		return
+	}
+	w, h := r.Dx(), r.Dy()
+	buf := make([]uint8, max(w, h)*4)
+	for pass := 0; pass < 2; pass++ {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			o := dst.PixOffset(r.Min.X, y)
+			blurLine(dst.Pix[o:o+w*4], 4, w, radius, buf)
+		}
+		for x := r.Min.X; x < r.Max.X; x++ {
+			o := dst.PixOffset(x, r.Min.Y)
+			blurLine(dst.Pix[o:], dst.Stride, h, radius, buf)
+		}
+	}
+}
+
+// blurLine blurs n RGBA pixels spaced stride bytes apart, using tmp as scratch.
+func blurLine(pix []uint8, stride, n, radius int, tmp []uint8) {
+	for i := 0; i < n; i++ {
+		copy(tmp[i*4:i*4+4], pix[i*stride:i*stride+4])
+	}
+	var acc [3]int
+	count := 0
+	for i := 0; i <= radius && i < n; i++ {
+		for c := 0; c < 3; c++ {
+			acc[c] += int(tmp[i*4+c])
+		}
+		count++
+	}
+	for i := 0; i < n; i++ {
+		o := i * stride
+		for c := 0; c < 3; c++ {
+			pix[o+c] = uint8(acc[c] / count)
+		}
+		if j := i + radius + 1; j < n {
+			for c := 0; c < 3; c++ {
+				acc[c] += int(tmp[j*4+c])
+			}
+			count++
+		}
+		if j := i - radius; j >= 0 {
+			for c := 0; c < 3; c++ {
+				acc[c] -= int(tmp[j*4+c])
+			}
+			count--
+		}
+	}
+}
+
+// fillPolygon fills pts using the even-odd rule with horizontal scanlines.
+func fillPolygon(dst *image.RGBA, pts []image.Point, col color.RGBA) {
+	b := dst.Bounds()
+	minY, maxY := pts[0].Y, pts[0].Y
+	for _, p := range pts {
+		minY = min(minY, p.Y)
+		maxY = max(maxY, p.Y)
+	}
+	minY = max(minY, b.Min.Y)
+	maxY = min(maxY, b.Max.Y)
+	xs := make([]int, 0, len(pts))
+	for y := minY; y < maxY; y++ {
+		fy := float64(y) + 0.5
+		xs = xs[:0]
+		for i := range pts {
+			a, c := pts[i], pts[(i+1)%len(pts)]
+			if (float64(a.Y) <= fy) == (float64(c.Y) <= fy) {
+				continue
+			}
+			t := (fy - float64(a.Y)) / float64(c.Y-a.Y)
+			xs = append(xs, int(math.Round(float64(a.X)+t*float64(c.X-a.X))))
+		}
+		sort.Ints(xs)
+		for i := 0; i+1 < len(xs); i += 2 {
+			fillRect(dst, image.Rect(xs[i], y, xs[i+1], y+1), col)
+		}
+	}
+}
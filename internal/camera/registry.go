@@ -9,6 +9,7 @@ type Registry struct {
 	mu      sync.RWMutex
 	cameras map[string]*Camera
 	factory CameraFactory
+	mosaics map[string]*Mosaic // running mosaics by MosaicSpec.key
 }
 
 func NewRegistry(factory CameraFactory) *Registry {
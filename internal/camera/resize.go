@@ -0,0 +1,106 @@
+package camera
+
+import "image"
+
+// fitSize returns the largest size with the aspect ratio of src that fits
+// in maxW x maxH, never larger than src. A zero bound leaves that axis free.
+func fitSize(src image.Point, maxW, maxH int) image.Point {
+	s := 1.0
+	if maxW > 0 && maxW < src.X {
+		s = float64(maxW) / float64(src.X)
+	}
+	if maxH > 0 && maxH < src.Y {
+		s = min(s, float64(maxH)/float64(src.Y))
+	}
+	if s == 1 {
+		return src
+	}
+	return image.Pt(max(int(float64(src.X)*s+0.5), 1), max(int(float64(src.Y)*s+0.5), 1))
+}
+
+// resizeRGBA scales src to w x h with an area (box) filter, the right
+// choice for the modest downscales left after a DCT-scaled decode.
+// Enlarging falls back to nearest neighbour.
+func resizeRGBA(src *image.RGBA, w, h int) *image.RGBA {
+	sb := src.Bounds()
+	if sb.Dx() == w && sb.Dy() == h {
+		return src
+	}
+	xt := areaTaps(sb.Dx(), w)
+	yt := areaTaps(sb.Dy(), h)
+
+	// horizontal pass into a w x src-height buffer
+	tmp := make([]uint8, w*4*sb.Dy())
+	for y := 0; y < sb.Dy(); y++ {
+		row := src.Pix[src.PixOffset(sb.Min.X, sb.Min.Y+y):]
+		d := tmp[y*w*4:]
+		for x, t := range xt {
+			var acc [4]int32
+			for k, wt := range t.w {
+				p := row[(t.first+k)*4:]
+				acc[0] += int32(p[0]) * wt
+				acc[1] += int32(p[1]) * wt
+				acc[2] += int32(p[2]) * wt
+				acc[3] += int32(p[3]) * wt
+			}
+			for c := 0; c < 4; c++ {
+				d[x*4+c] = uint8((acc[c] + 1<<(areaBits-1)) >> areaBits)
+			}
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y, t := range yt {
+		d := dst.Pix[y*dst.Stride : y*dst.Stride+w*4]
+		for i := range d {
+			var acc int32
+			for k, wt := range t.w {
+				acc += int32(tmp[(t.first+k)*w*4+i]) * wt
+			}
+			d[i] = uint8((acc + 1<<(areaBits-1)) >> areaBits)
+		}
+	}
+	return dst
+}
+
+const areaBits = 14
+
+// areaTap is the run of source samples covering one destination sample,
+// with weights in areaBits fixed point summing to 1<<areaBits.
+type areaTap struct {
+	first int
+	w     []int32
+}
+
+func areaTaps(src, dst int) []areaTap {
+	taps := make([]areaTap, dst)
+	scale := float64(src) / float64(dst)
+	for i := range taps {
+		lo, hi := float64(i)*scale, float64(i+1)*scale
+		if scale < 1 {
+			// enlarging: one source sample per destination sample
+			c := min(int((lo+hi)/2), src-1)
+			taps[i] = areaTap{first: c, w: []int32{1 << areaBits}}
+			continue
+		}
+		first := int(lo)
+		last := min(int(hi+0.999999), src) - 1
+		t := areaTap{first: first, w: make([]int32, last-first+1)}
+		var sum int32
+		for k := range t.w {
+			a := min(hi, float64(first+k+1)) - max(lo, float64(first+k))
+			t.w[k] = int32(a / scale * (1 << areaBits))
+			sum += t.w[k]
+		}
+		// put the rounding error on the largest weight
+		big := 0
+		for k := range t.w {
+			if t.w[k] > t.w[big] {
+				big = k
+			}
+		}
+		t.w[big] += 1<<areaBits - sum
+		taps[i] = t
+	}
+	return taps
+}
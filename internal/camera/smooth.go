@@ -0,0 +1,178 @@
+package camera
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+
+	"Garage48/internal/detector"
+)
+
+// SmoothConfig steadies boxes between detector results. The zero value
+// draws every result as it comes, as without smoothing.
+type SmoothConfig struct {
+	// A box is shown once its object was found in Confirm of the last
+	// Window results (N-of-M). Confirm 0 or 1 shows it at once; Window
+	// defaults to Confirm.
+	Confirm int `json:"confirm"`
+	Window  int `json:"window"`
+	// HoldMS keeps showing a box this long after its object was last found.
+	HoldMS int `json:"hold_ms"`
+	// Interpolate glides boxes from the previous result's position to the
+	// latest one over the frames published until the next result. Boxes
+	// trail the detector by one result in exchange, so they never overshoot
+	// an object that stops or turns.
+	Interpolate bool `json:"interpolate"`
+}
+
+func (c SmoothConfig) enabled() bool { return c.Confirm > 1 || c.HoldMS > 0 || c.Interpolate }
+
+// smoothMatchIoU is the overlap at which a box continues a track of the
+// same label when the backend does not track objects itself.
+const smoothMatchIoU = 0.3
+
+// smoother follows objects across detector results. Results arrive from the
+// detect worker and boxes are read by the frame loop, hence the mutex.
+type smoother struct {
+	cfg    SmoothConfig
+	hold   time.Duration
+	window int
+	maxAge time.Duration // of a result still shown without a hold
+
+	mu     sync.Mutex
+	frame  detector.Frame
+	tracks []*smoothTrack
+}
+
+type smoothTrack struct {
+	box       detector.Box // as last found
+	prev      detector.Box // as found the time before, to interpolate from
+	seenAt    time.Time
+	prevAt    time.Time // zero unless prev is set
+	hits      uint64    // bit i set if found i results ago
+	missed    int       // results in a row without it
+	confirmed bool
+}
+
+func newSmoother(cfg SmoothConfig, maxAge time.Duration) *smoother {
+	if !cfg.enabled() {
+		return nil
+	}
+	s := &smoother{cfg: cfg, hold: time.Duration(cfg.HoldMS) * time.Millisecond, maxAge: maxAge}
+	s.window = min(max(cfg.Window, cfg.Confirm, 1), 64)
+	return s
+}
+
+// update folds in a new result produced at at.
+func (s *smoother) update(res detector.Result, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if res.Width != s.frame.Width || res.Height != s.frame.Height {
+		s.tracks = nil // positions do not carry over to another frame size
+	}
+	s.frame = res.Frame
+	mask := uint64(1)<<s.window - 1
+	for _, t := range s.tracks {
+		t.hits = t.hits << 1 & mask
+	}
+	taken := make([]bool, len(s.tracks))
+	for _, b := range res.Boxes {
+		if i := s.match(b, taken); i >= 0 {
+			t := s.tracks[i]
+			taken[i] = true
+			t.prev, t.prevAt = t.box, t.seenAt
+			t.box, t.seenAt = b, at
+			t.hits |= 1
+			t.missed = 0
+		} else {
+			s.tracks = append(s.tracks, &smoothTrack{box: b, seenAt: at, hits: 1})
+		}
+	}
+	kept := s.tracks[:0]
+	for i, t := range s.tracks {
+		if i < len(taken) && !taken[i] {
+			t.missed++
+		}
+		if !t.confirmed && bits.OnesCount64(t.hits) >= max(s.cfg.Confirm, 1) {
+			t.confirmed = true
+		}
+		// a candidate lives as long as it has hits in the window; only
+		// confirmed tracks are held
+		if !t.confirmed && t.hits == 0 || t.confirmed && t.missed > 0 && at.Sub(t.seenAt) > s.hold {
+			continue
+		}
+		kept = append(kept, t)
+	}
+	clear(s.tracks[len(kept):])
+	s.tracks = kept
+}
+
+// match returns the free track b continues, or -1. Backend track IDs win;
+// otherwise the best overlapping track of the same label.
+func (s *smoother) match(b detector.Box, taken []bool) int {
+	best, bestIoU := -1, smoothMatchIoU
+	for i, t := range s.tracks[:len(taken)] {
+		if taken[i] || t.box.Label != b.Label {
+			continue
+		}
+		if b.TrackID != 0 && t.box.TrackID != 0 {
+			if b.TrackID == t.box.TrackID {
+				return i
+			}
+			continue
+		}
+		if v := iou(t.box, b); v >= bestIoU {
+			best, bestIoU = i, v
+		}
+	}
+	return best
+}
+
+// boxes returns what to draw at now: confirmed tracks, found in the latest
+// result or held, on their way from the previous result if interpolation
+// is on.
+func (s *smoother) boxes(now time.Time) (detector.Result, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []detector.Box
+	for _, t := range s.tracks {
+		age := now.Sub(t.seenAt)
+		if !t.confirmed || (t.missed == 0 && age > max(s.maxAge, s.hold)) || (t.missed > 0 && age > s.hold) {
+			continue
+		}
+		b := t.box
+		if s.cfg.Interpolate && t.missed == 0 && !t.prevAt.IsZero() {
+			b = interpolate(t.prev, t.box, t.seenAt.Sub(t.prevAt), age)
+		}
+		out = append(out, b)
+	}
+	res := detector.NewResult(s.frame, out)
+	return res, len(res.Boxes) > 0
+}
+
+// interpolate places a box age after cur was found on the way from prev to
+// cur, taking as long as the two results were apart, dt. It starts at prev
+// and stays at cur once dt has passed, never going beyond either.
+func interpolate(prev, cur detector.Box, dt, age time.Duration) detector.Box {
+	if dt <= 0 || age >= dt {
+		return cur
+	}
+	f := max(float64(age)/float64(dt), 0)
+	step := func(a, b int) int { return a + int(math.Round(float64(b-a)*f)) }
+	out := cur
+	out.X1, out.Y1 = step(prev.X1, cur.X1), step(prev.Y1, cur.Y1)
+	out.X2, out.Y2 = step(prev.X2, cur.X2), step(prev.Y2, cur.Y2)
+	return out
+}
+
+func iou(a, b detector.Box) float64 {
+	ix := min(a.X2, b.X2) - max(a.X1, b.X1)
+	iy := min(a.Y2, b.Y2) - max(a.Y1, b.Y1)
+	if ix <= 0 || iy <= 0 {
+		return 0
+	}
+	inter := float64(ix) * float64(iy)
+	union := float64((a.X2-a.X1)*(a.Y2-a.Y1)+(b.X2-b.X1)*(b.Y2-b.Y1)) - inter
+	return inter / union
+}
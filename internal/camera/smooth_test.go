@@ -0,0 +1,165 @@
+package camera
+
+import (
+	"testing"
+	"time"
+
+	"Garage48/internal/detector"
+)
+
+func smoothBox(x int) detector.Box {
+	return detector.Box{Label: "person", Conf: 0.9, X1: x, Y1: 10, X2: x + 40, Y2: 90}
+}
+
+// smoothStep feeds a result at ms, or with check set, expects the boxes
+// drawn at ms to start at xs.
+type smoothStep struct {
+	ms    int
+	check bool
+	xs    []int
+}
+
+func TestSmoother(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   SmoothConfig
+		steps []smoothStep
+	}{
+		{"off", SmoothConfig{}, nil},
+		{"confirm 3 of 3", SmoothConfig{Confirm: 3}, []smoothStep{
+			{ms: 0, xs: []int{100}},
+			{ms: 0, check: true},
+			{ms: 100, xs: []int{102}},
+			{ms: 100, check: true},
+			{ms: 200, xs: []int{104}},
+			{ms: 200, check: true, xs: []int{104}},
+		}},
+		{"confirm 2 of 4 across a gap", SmoothConfig{Confirm: 2, Window: 4, HoldMS: 1000}, []smoothStep{
+			{ms: 0, xs: []int{100}},
+			{ms: 100},
+			{ms: 100, check: true},
+			{ms: 200, xs: []int{100}},
+			{ms: 200, check: true, xs: []int{100}},
+		}},
+		{"confirm 2 of 4 across a gap without hold", SmoothConfig{Confirm: 2, Window: 4}, []smoothStep{
+			{ms: 0, xs: []int{100}},
+			{ms: 100},
+			{ms: 100, check: true},
+			{ms: 200, xs: []int{100}},
+			{ms: 200, check: true, xs: []int{100}},
+		}},
+		{"unconfirmed track falls out of the window", SmoothConfig{Confirm: 2, Window: 2, HoldMS: 1000}, []smoothStep{
+			{ms: 0, xs: []int{100}},
+			{ms: 100},
+			{ms: 200},
+			{ms: 300, xs: []int{100}},
+			{ms: 300, check: true},
+		}},
+		{"separate objects confirm separately", SmoothConfig{Confirm: 2}, []smoothStep{
+			{ms: 0, xs: []int{100}},
+			{ms: 100, xs: []int{100, 400}},
+			{ms: 100, check: true, xs: []int{100}},
+			{ms: 200, xs: []int{100, 400}},
+			{ms: 200, check: true, xs: []int{100, 400}},
+		}},
+		{"hold", SmoothConfig{HoldMS: 300}, []smoothStep{
+			{ms: 0, xs: []int{100}},
+			{ms: 100},
+			{ms: 250, check: true, xs: []int{100}},
+			{ms: 299, check: true, xs: []int{100}},
+			{ms: 301, check: true},
+		}},
+		{"hold ends on the next result", SmoothConfig{HoldMS: 300}, []smoothStep{
+			{ms: 0, xs: []int{100}},
+			{ms: 350},
+			{ms: 350, check: true},
+		}},
+		{"expire without hold", SmoothConfig{Confirm: 2}, []smoothStep{
+			{ms: 0, xs: []int{100}},
+			{ms: 100, xs: []int{100}},
+			{ms: 500, check: true, xs: []int{100}},
+			{ms: 601, check: true},
+		}},
+		{"vanished without hold", SmoothConfig{Confirm: 2}, []smoothStep{
+			{ms: 0, xs: []int{100}},
+			{ms: 100, xs: []int{100}},
+			{ms: 200},
+			{ms: 200, check: true},
+		}},
+		{"interpolate", SmoothConfig{Interpolate: true}, []smoothStep{
+			{ms: 0, xs: []int{100}},
+			{ms: 0, check: true, xs: []int{100}},
+			{ms: 200, xs: []int{120}},
+			{ms: 200, check: true, xs: []int{100}},
+			{ms: 300, check: true, xs: []int{110}},
+			{ms: 400, check: true, xs: []int{120}},
+			{ms: 450, check: true, xs: []int{120}}, // stopped: no overshoot
+			{ms: 450, xs: []int{120}},
+			{ms: 500, check: true, xs: []int{120}},
+		}},
+		{"interpolate from a held box", SmoothConfig{Interpolate: true, HoldMS: 1000}, []smoothStep{
+			{ms: 0, xs: []int{100}},
+			{ms: 100},
+			{ms: 150, check: true, xs: []int{100}},
+			{ms: 200, xs: []int{120}},
+			{ms: 200, check: true, xs: []int{100}},
+			{ms: 300, check: true, xs: []int{110}},
+			{ms: 400, check: true, xs: []int{120}},
+		}},
+	}
+	t0 := time.Unix(1000, 0)
+	frame := detector.Frame{Width: 640, Height: 480}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSmoother(tt.cfg, detectionMaxAge)
+			if (s == nil) != !tt.cfg.enabled() {
+				t.Fatalf("newSmoother returned %v", s)
+			}
+			for _, st := range tt.steps {
+				at := t0.Add(time.Duration(st.ms) * time.Millisecond)
+				if !st.check {
+					var boxes []detector.Box
+					for _, x := range st.xs {
+						boxes = append(boxes, smoothBox(x))
+					}
+					s.update(detector.NewResult(frame, boxes), at)
+					continue
+				}
+				res, ok := s.boxes(at)
+				var got []int
+				for _, b := range res.Boxes {
+					got = append(got, b.X1)
+				}
+				if ok != (len(st.xs) > 0) || !equalInts(got, st.xs) {
+					t.Errorf("at %dms: got %v (%v), want %v", st.ms, got, ok, st.xs)
+				}
+			}
+		})
+	}
+}
+
+func TestSmootherTrackIDs(t *testing.T) {
+	s := newSmoother(SmoothConfig{Confirm: 2}, detectionMaxAge)
+	t0 := time.Unix(1000, 0)
+	frame := detector.Frame{Width: 640, Height: 480}
+	a, b := smoothBox(100), smoothBox(104)
+	a.TrackID, b.TrackID = 1, 2
+	s.update(detector.NewResult(frame, []detector.Box{a}), t0)
+	// b overlaps a but is another object: it must not confirm a's track
+	s.update(detector.NewResult(frame, []detector.Box{b}), t0.Add(100*time.Millisecond))
+	if res, ok := s.boxes(t0.Add(100 * time.Millisecond)); ok {
+		t.Errorf("got %v, want nothing confirmed", res.Boxes)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
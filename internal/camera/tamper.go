@@ -0,0 +1,350 @@
+package camera
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sync"
+	"time"
+)
+
+// Tamper condition kinds reported by the scene-health analyzer.
+const (
+	TamperCovered   = "covered"
+	TamperMoved     = "moved"
+	TamperDefocused = "defocused"
+	TamperDark      = "dark"
+)
+
+// TamperConfig controls the scene-health analyzer. Zero values pick defaults.
+type TamperConfig struct {
+	Enabled     bool    `json:"enabled"`
+	IntervalMS  int     `json:"interval_ms"`  // analysis period (default 1000)
+	Confirm     int     `json:"confirm"`      // consecutive analyses to raise or clear a condition (default 3)
+	DarkMean    float64 `json:"dark_mean"`    // mean luma below which the scene is under-exposed (default 28)
+	ObstructStd float64 `json:"obstruct_std"` // luma stddev below which the lens is considered covered (default 8)
+	BlurRatio   float64 `json:"blur_ratio"`   // sharpness vs reference below which the view is defocused (default 0.3)
+	SceneChange float64 `json:"scene_change"` // fraction of grid cells differing from reference to flag a move (default 0.5)
+}
+
+func (c TamperConfig) withDefaults() TamperConfig {
+	if c.IntervalMS <= 0 {
+		c.IntervalMS = 1000
+	}
+	if c.Confirm <= 0 {
+		c.Confirm = 3
+	}
+	if c.DarkMean <= 0 {
+		c.DarkMean = 28
+	}
+	if c.ObstructStd <= 0 {
+		c.ObstructStd = 8
+	}
+	if c.BlurRatio <= 0 {
+		c.BlurRatio = 0.3
+	}
+	if c.SceneChange <= 0 {
+		c.SceneChange = 0.5
+	}
+	return c
+}
+
+// SceneMetrics are the per-analysis measurements of a downscaled luma frame.
+type SceneMetrics struct {
+	Mean      float64 `json:"mean"`
+	StdDev    float64 `json:"stddev"`
+	Sharpness float64 `json:"sharpness"` // Laplacian variance
+	Changed   float64 `json:"changed"`   // fraction of grid cells differing from the reference
+}
+
+// TamperEvent records one raised condition. End is nil while it is active.
+type TamperEvent struct {
+	ID       uint64       `json:"id"`
+	Kind     string       `json:"kind"`
+	Start    time.Time    `json:"start"`
+	End      *time.Time   `json:"end,omitempty"`
+	Metrics  SceneMetrics `json:"metrics"`
+	Snapshot string       `json:"snapshot,omitempty"` // filled in by the HTTP layer
+
+	jpeg []byte
+}
+
+// TamperStatus is the analyzer state exposed through the camera status API.
+type TamperStatus struct {
+	Active     []string      `json:"active"`
+	Metrics    SceneMetrics  `json:"metrics"`
+	Reference  bool          `json:"reference"`
+	AnalyzedAt time.Time     `json:"analyzed_at"`
+	Events     []TamperEvent `json:"events"`
+}
+
+const (
+	tamperThumbWidth = 320
+	tamperGridW      = 8
+	tamperGridH      = 6
+	tamperCellDelta  = 0.25 // normalized cell-mean difference counted as changed
+	tamperMaxEvents  = 32
+)
+
+var tamperKinds = [...]string{TamperCovered, TamperDark, TamperDefocused, TamperMoved}
+
+type tamperAnalyzer struct {
+	cfg TamperConfig
+
+	mu       sync.Mutex
+	ref      []float64 // normalized grid cell means of the reference scene
+	refSharp float64
+	haveRef  bool
+	streak   map[string]int // positive: consecutive hits, negative: consecutive misses while active
+	active   map[string]*TamperEvent
+	events   []*TamperEvent
+	nextID   uint64
+	last     SceneMetrics
+	lastAt   time.Time
+}
+
+func newTamperAnalyzer(cfg TamperConfig) *tamperAnalyzer {
+	return &tamperAnalyzer{
+		cfg:    cfg.withDefaults(),
+		streak: make(map[string]int),
+		active: make(map[string]*TamperEvent),
+	}
+}
+
+// analyze measures one frame, updates condition state and returns the kinds
+// that were raised by this call. jpg is kept as the event snapshot.
+func (t *tamperAnalyzer) analyze(g *image.Gray, jpg []byte, now time.Time) []string {
+	m, grid := sceneMetrics(g)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.haveRef {
+		m.Changed = gridChanged(grid, t.ref)
+	}
+	hits := map[string]bool{}
+	switch {
+	case m.StdDev < t.cfg.ObstructStd:
+		hits[TamperCovered] = true
+	case m.Mean < t.cfg.DarkMean:
+		hits[TamperDark] = true
+	case t.haveRef:
+		if m.Sharpness < t.cfg.BlurRatio*t.refSharp {
+			hits[TamperDefocused] = true
+		}
+		if m.Changed > t.cfg.SceneChange {
+			hits[TamperMoved] = true
+		}
+	}
+
+	var raised []string
+	for _, kind := range tamperKinds {
+		ev := t.active[kind]
+		switch {
+		case hits[kind] && ev == nil:
+			if t.streak[kind] < 0 {
+				t.streak[kind] = 0
+			}
+			t.streak[kind]++
+			if t.streak[kind] >= t.cfg.Confirm {
+				t.nextID++
+				ev = &TamperEvent{ID: t.nextID, Kind: kind, Start: now, Metrics: m, jpeg: jpg}
+				t.active[kind] = ev
+				t.events = append(t.events, ev)
+				if len(t.events) > tamperMaxEvents {
+					t.events = t.events[len(t.events)-tamperMaxEvents:]
+				}
+				t.streak[kind] = 0
+				raised = append(raised, kind)
+			}
+		case !hits[kind] && ev != nil:
+			t.streak[kind]--
+			if -t.streak[kind] >= t.cfg.Confirm {
+				end := now
+				ev.End = &end
+				delete(t.active, kind)
+				t.streak[kind] = 0
+			}
+		case hits[kind]:
+			t.streak[kind] = 0 // still active
+		default:
+			t.streak[kind] = 0
+		}
+	}
+
+	// Track slow scene drift (daylight, small re-aims) only while the view is healthy.
+	if len(hits) == 0 && len(t.active) == 0 {
+		if !t.haveRef {
+			t.ref = grid
+			t.refSharp = m.Sharpness
+			t.haveRef = true
+		} else {
+			for i := range t.ref {
+				t.ref[i] = 0.9*t.ref[i] + 0.1*grid[i]
+			}
+			t.refSharp = 0.9*t.refSharp + 0.1*m.Sharpness
+		}
+	}
+	t.last = m
+	t.lastAt = now
+	return raised
+}
+
+// reset drops the reference scene so the next healthy frame becomes the new
+// baseline, and closes all active events.
+func (t *tamperAnalyzer) reset(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for kind, ev := range t.active {
+		end := now
+		ev.End = &end
+		delete(t.active, kind)
+	}
+	t.streak = make(map[string]int)
+	t.haveRef = false
+	t.ref = nil
+}
+
+func (t *tamperAnalyzer) status() TamperStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := TamperStatus{
+		Active:     make([]string, 0, len(t.active)),
+		Metrics:    t.last,
+		Reference:  t.haveRef,
+		AnalyzedAt: t.lastAt,
+		Events:     make([]TamperEvent, 0, len(t.events)),
+	}
+	for _, kind := range tamperKinds {
+		if t.active[kind] != nil {
+			st.Active = append(st.Active, kind)
+		}
+	}
+	for i := len(t.events) - 1; i >= 0; i-- {
+		ev := *t.events[i]
+		if ev.End != nil {
+			end := *ev.End
+			ev.End = &end
+		}
+		ev.jpeg = nil
+		st.Events = append(st.Events, ev)
+	}
+	return st
+}
+
+func (t *tamperAnalyzer) snapshot(id uint64) []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, ev := range t.events {
+		if ev.ID == id {
+			return ev.jpeg
+		}
+	}
+	return nil
+}
+
+// sceneMetrics computes luma statistics, Laplacian variance and the
+// mean-normalized grid of cell averages used for scene-change detection.
+func sceneMetrics(g *image.Gray) (SceneMetrics, []float64) {
+	var m SceneMetrics
+	b := g.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w < 3 || h < 3 {
+		return m, make([]float64, tamperGridW*tamperGridH)
+	}
+	cells := make([]float64, tamperGridW*tamperGridH)
+	counts := make([]int, len(cells))
+	var sum, sumSq float64
+	for y := 0; y < h; y++ {
+		row := g.Pix[y*g.Stride : y*g.Stride+w]
+		cy := y * tamperGridH / h
+		for x, p := range row {
+			v := float64(p)
+			sum += v
+			sumSq += v * v
+			ci := cy*tamperGridW + x*tamperGridW/w
+			cells[ci] += v
+			counts[ci]++
+		}
+	}
+	n := float64(w * h)
+	m.Mean = sum / n
+	m.StdDev = math.Sqrt(math.Max(0, sumSq/n-m.Mean*m.Mean))
+
+	var lsum, lsumSq float64
+	for y := 1; y < h-1; y++ {
+		o := y * g.Stride
+		for x := 1; x < w-1; x++ {
+			l := 4*float64(g.Pix[o+x]) - float64(g.Pix[o+x-1]) - float64(g.Pix[o+x+1]) -
+				float64(g.Pix[o+x-g.Stride]) - float64(g.Pix[o+x+g.Stride])
+			lsum += l
+			lsumSq += l * l
+		}
+	}
+	ln := float64((w - 2) * (h - 2))
+	lmean := lsum / ln
+	m.Sharpness = lsumSq/ln - lmean*lmean
+
+	norm := math.Max(m.Mean, 1)
+	for i := range cells {
+		if counts[i] > 0 {
+			cells[i] = cells[i] / float64(counts[i]) / norm
+		}
+	}
+	return m, cells
+}
+
+func gridChanged(cur, ref []float64) float64 {
+	if len(cur) != len(ref) || len(cur) == 0 {
+		return 0
+	}
+	changed := 0
+	for i := range cur {
+		if math.Abs(cur[i]-ref[i]) > tamperCellDelta {
+			changed++
+		}
+	}
+	return float64(changed) / float64(len(cur))
+}
+
+// lumaThumb converts a decoded frame to a grayscale image at most maxW wide
+// using box averaging. YCbCr and Gray frames are read without conversion.
+func lumaThumb(src image.Image, maxW int) *image.Gray {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	step := 1
+	for sw/step > maxW {
+		step++
+	}
+	dw, dh := sw/step, sh/step
+	dst := image.NewGray(image.Rect(0, 0, dw, dh))
+	if dw == 0 || dh == 0 {
+		return dst
+	}
+	lumaAt := func(x, y int) uint32 {
+		return uint32(color.GrayModel.Convert(src.At(x, y)).(color.Gray).Y)
+	}
+	switch m := src.(type) {
+	case *image.YCbCr:
+		lumaAt = func(x, y int) uint32 {
+			return uint32(m.Y[m.YOffset(x, y)])
+		}
+	case *image.Gray:
+		lumaAt = func(x, y int) uint32 {
+			return uint32(m.Pix[m.PixOffset(x, y)])
+		}
+	}
+	area := uint32(step * step)
+	for dy := 0; dy < dh; dy++ {
+		for dx := 0; dx < dw; dx++ {
+			var acc uint32
+			for y := 0; y < step; y++ {
+				for x := 0; x < step; x++ {
+					acc += lumaAt(b.Min.X+dx*step+x, b.Min.Y+dy*step+y)
+				}
+			}
+			dst.Pix[dy*dst.Stride+dx] = uint8(acc / area)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,276 @@
+package camera
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sync"
+
+	"Garage48/internal/detector"
+	"Garage48/internal/jpegfast"
+)
+
+// TransformConfig orients and frames a camera's picture. Rotation and
+// mirroring are applied first; Crop is given in the rotated picture and
+// Zoom/Pan select a window inside the crop that is scaled back to crop size.
+type TransformConfig struct {
+	Rotate int       `json:"rotate"` // clockwise degrees: 0, 90, 180 or 270
+	FlipH  bool      `json:"flip_h"` // mirror left-right
+	FlipV  bool      `json:"flip_v"` // mirror top-bottom
+	Crop   *CropRect `json:"crop,omitempty"`
+	Zoom   float64   `json:"zoom"`  // digital zoom factor, 1 (or 0) for none, up to 8
+	PanX   float64   `json:"pan_x"` // zoom window position inside the crop, -1 (left) .. 1 (right)
+	PanY   float64   `json:"pan_y"` // -1 (top) .. 1 (bottom)
+}
+
+// CropRect is a pixel rectangle in the rotated picture.
+type CropRect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+const maxZoom = 8
+
+func (t TransformConfig) validate() error {
+	switch t.Rotate {
+	case 0, 90, 180, 270:
+	default:
+		return fmt.Errorf("rotate must be 0, 90, 180 or 270, got %d", t.Rotate)
+	}
+	if t.Zoom != 0 && (t.Zoom < 1 || t.Zoom > maxZoom) {
+		return fmt.Errorf("zoom must be between 1 and %d, got %g", maxZoom, t.Zoom)
+	}
+	if math.Abs(t.PanX) > 1 || math.Abs(t.PanY) > 1 {
+		return fmt.Errorf("pan must be between -1 and 1")
+	}
+	if t.Crop != nil && (t.Crop.W < 0 || t.Crop.H < 0 || t.Crop.X < 0 || t.Crop.Y < 0) {
+		return fmt.Errorf("crop must not be negative")
+	}
+	return nil
+}
+
+func (t TransformConfig) identity() bool {
+	return t.Rotate == 0 && !t.FlipH && !t.FlipV && t.Crop == nil && t.Zoom <= 1
+}
+
+// transformer applies a TransformConfig to decoded frames using cached
+// nearest-neighbour lookup tables, and maps detector boxes the same way.
+type transformer struct {
+	mu  sync.RWMutex
+	cfg TransformConfig
+
+	// lookup tables for the last source size
+	lutMu   sync.Mutex
+	lutCfg  TransformConfig
+	lutSrc  image.Point
+	lutCrop image.Rectangle
+	colOff  []int // source byte offset contribution per output column
+	rowOff  []int // source byte offset contribution per output row
+}
+
+func newTransformer(cfg TransformConfig) *transformer {
+	return &transformer{cfg: cfg}
+}
+
+func (t *transformer) config() TransformConfig {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	cfg := t.cfg
+	if cfg.Crop != nil {
+		c := *cfg.Crop
+		cfg.Crop = &c
+	}
+	return cfg
+}
+
+func (t *transformer) set(cfg TransformConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.cfg = cfg
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *transformer) active() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return !t.cfg.identity()
+}
+
+// lossless returns the config as a coefficient-domain JPEG transform. It
+// fails for zoomed views, which need resampling; crops that are not
+// MCU-aligned are rejected later by the Perfect flag.
+func (t *transformer) lossless() (jpegfast.Transform, bool) {
+	cfg := t.config()
+	if cfg.Zoom > 1 {
+		return jpegfast.Transform{}, false
+	}
+	lt := jpegfast.Transform{Rotate: cfg.Rotate, FlipH: cfg.FlipH, FlipV: cfg.FlipV, Perfect: true}
+	if c := cfg.Crop; c != nil && c.W > 0 && c.H > 0 {
+		lt.Crop = image.Rect(c.X, c.Y, c.X+c.W, c.Y+c.H)
+	}
+	return lt, true
+}
+
+// geometry resolves a config against a source size: the oriented size,
+// the crop rectangle and the zoom window (both in oriented coordinates).
+func geometry(cfg TransformConfig, src image.Point) (oriented image.Point, crop image.Rectangle, view [4]float64) {
+	oriented = src
+	if cfg.Rotate == 90 || cfg.Rotate == 270 {
+		oriented = image.Pt(src.Y, src.X)
+	}
+	crop = image.Rectangle{Max: oriented}
+	if cfg.Crop != nil && cfg.Crop.W > 0 && cfg.Crop.H > 0 {
+		crop = image.Rect(cfg.Crop.X, cfg.Crop.Y, cfg.Crop.X+cfg.Crop.W, cfg.Crop.Y+cfg.Crop.H).Intersect(crop)
+		if crop.Empty() {
+			crop = image.Rectangle{Max: oriented}
+		}
+	}
+	zoom := math.Max(cfg.Zoom, 1)
+	cw, ch := float64(crop.Dx()), float64(crop.Dy())
+	vw, vh := cw/zoom, ch/zoom
+	cx := float64(crop.Min.X) + cw/2 + cfg.PanX*(cw-vw)/2
+	cy := float64(crop.Min.Y) + ch/2 + cfg.PanY*(ch-vh)/2
+	view = [4]float64{cx - vw/2, cy - vh/2, vw, vh}
+	return oriented, crop, view
+}
+
+// toSource maps oriented coordinates (u, v) back to source coordinates.
+func toSource(cfg TransformConfig, src, oriented image.Point, u, v float64) (float64, float64) {
+	if cfg.FlipH {
+		u = float64(oriented.X) - u
+	}
+	if cfg.FlipV {
+		v = float64(oriented.Y) - v
+	}
+	w, h := float64(src.X), float64(src.Y)
+	switch cfg.Rotate {
+	case 90:
+		return v, h - u
+	case 180:
+		return w - u, h - v
+	case 270:
+		return w - v, u
+	}
+	return u, v
+}
+
+// fromSource maps source coordinates (x, y) to oriented coordinates.
+func fromSource(cfg TransformConfig, src, oriented image.Point, x, y float64) (float64, float64) {
+	w, h := float64(src.X), float64(src.Y)
+	u, v := x, y
+	switch cfg.Rotate {
+	case 90:
+		u, v = h-y, x
+	case 180:
+		u, v = w-x, h-y
+	case 270:
+		u, v = y, w-x
+	}
+	if cfg.FlipH {
+		u = float64(oriented.X) - u
+	}
+	if cfg.FlipV {
+		v = float64(oriented.Y) - v
+	}
+	return u, v
+}
+
+// apply returns the transformed frame, or src itself for the identity transform.
+func (t *transformer) apply(src *image.RGBA) *image.RGBA {
+	cfg := t.config()
+	if cfg.identity() {
+		return src
+	}
+	sb := src.Bounds()
+	size := sb.Size()
+
+	t.lutMu.Lock()
+	defer t.lutMu.Unlock()
+	if size != t.lutSrc || !sameTransform(cfg, t.lutCfg) {
+		t.buildLUT(cfg, size, src.Stride)
+	}
+	out := image.NewRGBA(image.Rect(0, 0, t.lutCrop.Dx(), t.lutCrop.Dy()))
+	base := src.PixOffset(sb.Min.X, sb.Min.Y)
+	for oy, ro := range t.rowOff {
+		d := out.Pix[oy*out.Stride : oy*out.Stride+len(t.colOff)*4]
+		for ox, co := range t.colOff {
+			s := base + ro + co
+			copy(d[ox*4:ox*4+4], src.Pix[s:s+4])
+		}
+	}
+	return out
+}
+
+func (t *transformer) buildLUT(cfg TransformConfig, src image.Point, stride int) {
+	oriented, crop, view := geometry(cfg, src)
+	ow, oh := crop.Dx(), crop.Dy()
+	t.colOff = make([]int, ow)
+	t.rowOff = make([]int, oh)
+	clampX := func(x float64) int { return min(max(int(math.Floor(x)), 0), src.X-1) }
+	clampY := func(y float64) int { return min(max(int(math.Floor(y)), 0), src.Y-1) }
+	// Every supported transform maps output columns onto a single source axis
+	// and output rows onto the other, so offsets separate into row + column parts.
+	swap := cfg.Rotate == 90 || cfg.Rotate == 270
+	for ox := range t.colOff {
+		x, y := toSource(cfg, src, oriented, view[0]+(float64(ox)+0.5)*view[2]/float64(ow), 0)
+		if swap {
+			t.colOff[ox] = clampY(y) * stride
+		} else {
+			t.colOff[ox] = clampX(x) * 4
+		}
+	}
+	for oy := range t.rowOff {
+		x, y := toSource(cfg, src, oriented, 0, view[1]+(float64(oy)+0.5)*view[3]/float64(oh))
+		if swap {
+			t.rowOff[oy] = clampX(x) * 4
+		} else {
+			t.rowOff[oy] = clampY(y) * stride
+		}
+	}
+	t.lutCfg = cfg
+	t.lutSrc = src
+	t.lutCrop = crop
+}
+
+// mapBoxes converts boxes from source-frame pixels to transformed-frame
+// pixels, clipping them to the output and dropping those left empty.
+func (t *transformer) mapBoxes(boxes []detector.Box, src image.Point) []detector.Box {
+	cfg := t.config()
+	if cfg.identity() || len(boxes) == 0 {
+		return boxes
+	}
+	oriented, crop, view := geometry(cfg, src)
+	ow, oh := float64(crop.Dx()), float64(crop.Dy())
+	bounds := image.Rect(0, 0, crop.Dx(), crop.Dy())
+	out := boxes[:0:0]
+	for _, b := range boxes {
+		u0, v0 := fromSource(cfg, src, oriented, float64(b.X1), float64(b.Y1))
+		u1, v1 := fromSource(cfg, src, oriented, float64(b.X2), float64(b.Y2))
+		r := image.Rect(
+			int(math.Round((u0-view[0])*ow/view[2])), int(math.Round((v0-view[1])*oh/view[3])),
+			int(math.Round((u1-view[0])*ow/view[2])), int(math.Round((v1-view[1])*oh/view[3])),
+		).Intersect(bounds)
+		if r.Empty() {
+			continue
+		}
+		b.X1, b.Y1, b.X2, b.Y2 = r.Min.X, r.Min.Y, r.Max.X, r.Max.Y
+		out = append(out, b)
+	}
+	return out
+}
+
+func sameTransform(a, b TransformConfig) bool {
+	if (a.Crop == nil) != (b.Crop == nil) {
+		return false
+	}
+	if a.Crop != nil && *a.Crop != *b.Crop {
+		return false
+	}
+	a.Crop, b.Crop = nil, nil
+	return a == b
+}
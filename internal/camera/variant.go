@@ -0,0 +1,186 @@
+package camera
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"sync"
+	"time"
+
+	"Garage48/internal/jpegfast"
+)
+
+// Variant is a smaller or more compressed rendition of a camera's frames
+// for viewers on slow links. Zero fields keep the published frame's size
+// or quality; the zero Variant is the published frame itself.
+type Variant struct {
+	MaxW    int // fit inside MaxW x MaxH keeping the aspect ratio; never upscales
+	MaxH    int
+	Quality int // 1..100
+	// Fill crops the centre of the picture to the aspect ratio of MaxW x MaxH
+	// before fitting, so the result fills the box; it needs both bounds.
+	Fill bool
+	// Raw renders the camera's own frame, without boxes, OSD, privacy masks
+	// or transform. It fails with ErrRawUnavailable under privacy masking.
+	Raw bool
+}
+
+// ErrRawUnavailable is returned for raw variants of cameras with privacy
+// masking, whose unprocessed frames must not leave the server.
+var ErrRawUnavailable = errors.New("raw frames are not available with privacy masking")
+
+const (
+	variantQuality = 80               // when only the size changes
+	variantTTL     = 30 * time.Second // unused variants are dropped after this
+)
+
+// variantCache renders each requested Variant once per published frame.
+type variantCache struct {
+	mu      sync.Mutex
+	entries map[Variant]*variantEntry
+}
+
+type variantEntry struct {
+	mu   sync.Mutex
+	seq  uint64
+	jpg  []byte
+	err  error
+	enc  *jpegfast.Encoder
+	used time.Time // guarded by variantCache.mu
+}
+
+func (vc *variantCache) entry(v Variant, now time.Time) *variantEntry {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	if vc.entries == nil {
+		vc.entries = make(map[Variant]*variantEntry)
+	}
+	for k, e := range vc.entries {
+		if now.Sub(e.used) > variantTTL {
+			delete(vc.entries, k)
+		}
+	}
+	e := vc.entries[v]
+	if e == nil {
+		q := v.Quality
+		if q == 0 {
+			q = variantQuality
+		}
+		enc, _ := jpegfast.NewEncoder(jpegfast.EncodeConfig{Quality: q, Subsampling: jpegfast.Subsample420})
+		e = &variantEntry{enc: enc}
+		vc.entries[v] = e
+	}
+	e.used = now
+	return e
+}
+
+// VariantJPEG returns the latest frame rendered as v, with its sequence
+// number. Viewers asking for the same Variant share a single decode and
+// encode per frame, so the cost follows the number of variants in use
+// rather than the number of viewers.
+func (c *Camera) VariantJPEG(v Variant) ([]byte, uint64, error) {
+	if v.Raw && c.privacy != nil {
+		return nil, 0, ErrRawUnavailable
+	}
+	src, seq := c.latestFrame(v.Raw)
+	if v.plain() || len(src) == 0 {
+		return src, seq, nil
+	}
+	e := c.variants.entry(v, time.Now())
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.seq != seq {
+		// a fresh buffer per frame: the previous one may still be in flight
+		e.jpg, e.err = renderVariant(src, v, e.enc)
+		e.seq = seq
+	}
+	return e.jpg, seq, e.err
+}
+
+// VariantImage is VariantJPEG for callers that re-encode in another format:
+// it returns the decoded pixels without the JPEG round trip, uncached.
+func (c *Camera) VariantImage(v Variant) (image.Image, uint64, error) {
+	if v.Raw && c.privacy != nil {
+		return nil, 0, ErrRawUnavailable
+	}
+	src, seq := c.latestFrame(v.Raw)
+	if len(src) == 0 {
+		return nil, seq, nil
+	}
+	img, err := variantPixels(src, v)
+	return img, seq, err
+}
+
+// plain reports whether v is the frame as published.
+func (v Variant) plain() bool {
+	v.Raw = false
+	return v == Variant{}
+}
+
+func renderVariant(src []byte, v Variant, enc *jpegfast.Encoder) ([]byte, error) {
+	if v.Quality == 0 {
+		size, err := jpegSize(src)
+		if err != nil {
+			return nil, err
+		}
+		if crop, out := v.geometry(size); crop.Size() == size && out == size {
+			return src, nil
+		}
+	}
+	img, err := variantPixels(src, v)
+	if err != nil {
+		return nil, err
+	}
+	return enc.AppendEncode(nil, img)
+}
+
+func jpegSize(src []byte) (image.Point, error) {
+	if hdr, err := jpegfast.DecodeHeader(src); err == nil {
+		return image.Pt(hdr.Width, hdr.Height), nil
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(src))
+	return image.Pt(cfg.Width, cfg.Height), err
+}
+
+// geometry returns the part of a size-pixel frame v shows and its output size.
+func (v Variant) geometry(size image.Point) (crop image.Rectangle, out image.Point) {
+	crop = image.Rectangle{Max: size}
+	if v.Fill && v.MaxW > 0 && v.MaxH > 0 {
+		// widest centred window with the box's aspect ratio
+		cw, ch := size.X, size.X*v.MaxH/v.MaxW
+		if ch > size.Y {
+			cw, ch = size.Y*v.MaxW/v.MaxH, size.Y
+		}
+		cw, ch = max(cw, 1), max(ch, 1)
+		crop = image.Rect(0, 0, cw, ch).Add(image.Pt((size.X-cw)/2, (size.Y-ch)/2))
+	}
+	return crop, fitSize(crop.Size(), v.MaxW, v.MaxH)
+}
+
+// variantPixels decodes src at the smallest DCT scale that still covers the
+// output and resamples the shown part to the output size.
+func variantPixels(src []byte, v Variant) (*image.RGBA, error) {
+	size, err := jpegSize(src)
+	if err != nil {
+		return nil, err
+	}
+	crop, out := v.geometry(size)
+	want := (out.X*size.X + crop.Dx() - 1) / crop.Dx()
+	rgba, err := jpegfast.DecodeRGBA(src, jpegfast.DecodeOptions{Scale: jpegfast.ScaleFor(size.X, want)})
+	if err != nil {
+		img, err := jpeg.Decode(bytes.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+		rgba = toRGBA(img)
+	}
+	if got := rgba.Bounds().Size(); got != size {
+		crop = image.Rect(crop.Min.X*got.X/size.X, crop.Min.Y*got.Y/size.Y,
+			(crop.Max.X*got.X+size.X-1)/size.X, (crop.Max.Y*got.Y+size.Y-1)/size.Y)
+	}
+	if crop != rgba.Bounds() {
+		rgba = rgba.SubImage(crop.Add(rgba.Bounds().Min)).(*image.RGBA)
+	}
+	return resizeRGBA(rgba, out.X, out.Y), nil
+}
@@ -0,0 +1,293 @@
+package dataset
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Export formats.
+const (
+	FormatYOLO = "yolo" // images/, labels/ with normalized centre boxes, data.yaml
+	FormatCOCO = "coco" // images/, annotations/instances_{train,val}.json
+	FormatVOC  = "voc"  // JPEGImages/, Annotations/*.xml, ImageSets/Main/{train,val}.txt
+)
+
+// ExportOptions selects what Export writes.
+type ExportOptions struct {
+	Format string
+	// Val is the fraction of samples put in the validation split, 0 to 1.
+	// A sample's split follows from its name, so it stays the same across
+	// exports as the dataset grows.
+	Val float64
+	// Cameras limits the export to these cameras; empty exports all.
+	Cameras []string
+}
+
+// Export writes the samples as a zip archive in the requested format.
+// Classes are the detected labels in alphabetical order.
+func (s *Store) Export(w io.Writer, opts ExportOptions) error {
+	var write func(*zip.Writer, []Sample, []bool, []string) error
+	switch opts.Format {
+	case FormatYOLO:
+		write = s.writeYOLO
+	case FormatCOCO:
+		write = s.writeCOCO
+	case FormatVOC:
+		write = s.writeVOC
+	default:
+		return fmt.Errorf("unknown dataset format %q", opts.Format)
+	}
+	if opts.Val < 0 || opts.Val > 1 {
+		return fmt.Errorf("validation fraction must be between 0 and 1, got %g", opts.Val)
+	}
+	all, err := s.List()
+	if err != nil {
+		return err
+	}
+	var samples []Sample
+	for _, smp := range all {
+		if len(opts.Cameras) == 0 || slices.Contains(opts.Cameras, smp.Camera) {
+			samples = append(samples, smp)
+		}
+	}
+	val := make([]bool, len(samples))
+	for i, smp := range samples {
+		val[i] = inVal(smp.Name, opts.Val)
+	}
+
+	zw := zip.NewWriter(w)
+	if err := write(zw, samples, val, classes(samples)); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func (s *Store) writeYOLO(zw *zip.Writer, samples []Sample, val []bool, names []string) error {
+	index := classIndex(names)
+	for i, smp := range samples {
+		split := splitName(val[i])
+		if err := s.copyImage(zw, "images/"+split+"/"+smp.Name+".jpg", smp.Name); err != nil {
+			return err
+		}
+		var b strings.Builder
+		fw, fh := float64(smp.Width), float64(smp.Height)
+		for _, box := range smp.Boxes {
+			cx := float64(box.X1+box.X2) / 2 / fw
+			cy := float64(box.Y1+box.Y2) / 2 / fh
+			bw := float64(box.X2-box.X1) / fw
+			bh := float64(box.Y2-box.Y1) / fh
+			fmt.Fprintf(&b, "%d %.6f %.6f %.6f %.6f\n", index[box.Label], cx, cy, bw, bh)
+		}
+		if err := writeEntry(zw, "labels/"+split+"/"+smp.Name+".txt", []byte(b.String())); err != nil {
+			return err
+		}
+	}
+	var y strings.Builder
+	y.WriteString("path: .\ntrain: images/train\nval: images/val\nnames:\n")
+	for i, n := range names {
+		fmt.Fprintf(&y, "  %d: %q\n", i, n)
+	}
+	return writeEntry(zw, "data.yaml", []byte(y.String()))
+}
+
+type cocoFile struct {
+	Images      []cocoImage      `json:"images"`
+	Annotations []cocoAnnotation `json:"annotations"`
+	Categories  []cocoCategory   `json:"categories"`
+}
+
+type cocoImage struct {
+	ID       int    `json:"id"`
+	FileName string `json:"file_name"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+}
+
+type cocoAnnotation struct {
+	ID         int        `json:"id"`
+	ImageID    int        `json:"image_id"`
+	CategoryID int        `json:"category_id"`
+	BBox       [4]float64 `json:"bbox"` // x, y, width, height
+	Area       float64    `json:"area"`
+	IsCrowd    int        `json:"iscrowd"`
+}
+
+type cocoCategory struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func (s *Store) writeCOCO(zw *zip.Writer, samples []Sample, val []bool, names []string) error {
+	index := classIndex(names)
+	files := map[string]*cocoFile{"train": {}, "val": {}}
+	for _, f := range files {
+		f.Images, f.Annotations = []cocoImage{}, []cocoAnnotation{}
+		for i, n := range names {
+			f.Categories = append(f.Categories, cocoCategory{ID: i + 1, Name: n})
+		}
+	}
+	for i, smp := range samples {
+		split := splitName(val[i])
+		if err := s.copyImage(zw, "images/"+split+"/"+smp.Name+".jpg", smp.Name); err != nil {
+			return err
+		}
+		f := files[split]
+		img := cocoImage{ID: len(f.Images) + 1, FileName: smp.Name + ".jpg", Width: smp.Width, Height: smp.Height}
+		f.Images = append(f.Images, img)
+		for _, b := range smp.Boxes {
+			w, h := float64(b.X2-b.X1), float64(b.Y2-b.Y1)
+			f.Annotations = append(f.Annotations, cocoAnnotation{
+				ID:         len(f.Annotations) + 1,
+				ImageID:    img.ID,
+				CategoryID: index[b.Label] + 1,
+				BBox:       [4]float64{float64(b.X1), float64(b.Y1), w, h},
+				Area:       w * h,
+			})
+		}
+	}
+	for _, split := range []string{"train", "val"} {
+		b, err := json.Marshal(files[split])
+		if err != nil {
+			return err
+		}
+		if err := writeEntry(zw, "annotations/instances_"+split+".json", b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type vocAnnotation struct {
+	XMLName   xml.Name    `xml:"annotation"`
+	Folder    string      `xml:"folder"`
+	Filename  string      `xml:"filename"`
+	Size      vocSize     `xml:"size"`
+	Segmented int         `xml:"segmented"`
+	Objects   []vocObject `xml:"object"`
+}
+
+type vocSize struct {
+	Width  int `xml:"width"`
+	Height int `xml:"height"`
+	Depth  int `xml:"depth"`
+}
+
+type vocObject struct {
+	Name      string `xml:"name"`
+	Pose      string `xml:"pose"`
+	Truncated int    `xml:"truncated"`
+	Difficult int    `xml:"difficult"`
+	BndBox    struct {
+		XMin int `xml:"xmin"`
+		YMin int `xml:"ymin"`
+		XMax int `xml:"xmax"`
+		YMax int `xml:"ymax"`
+	} `xml:"bndbox"`
+}
+
+func (s *Store) writeVOC(zw *zip.Writer, samples []Sample, val []bool, _ []string) error {
+	lists := map[string]*strings.Builder{"train": {}, "val": {}}
+	for i, smp := range samples {
+		if err := s.copyImage(zw, "JPEGImages/"+smp.Name+".jpg", smp.Name); err != nil {
+			return err
+		}
+		a := vocAnnotation{
+			Folder:   "JPEGImages",
+			Filename: smp.Name + ".jpg",
+			Size:     vocSize{Width: smp.Width, Height: smp.Height, Depth: 3},
+		}
+		for _, b := range smp.Boxes {
+			o := vocObject{Name: b.Label, Pose: "Unspecified"}
+			if b.X1 <= 0 || b.Y1 <= 0 || b.X2 >= smp.Width || b.Y2 >= smp.Height {
+				o.Truncated = 1
+			}
+			// VOC pixels count from 1 and include the last one
+			o.BndBox.XMin, o.BndBox.YMin = b.X1+1, b.Y1+1
+			o.BndBox.XMax, o.BndBox.YMax = b.X2, b.Y2
+			a.Objects = append(a.Objects, o)
+		}
+		out, err := xml.MarshalIndent(a, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeEntry(zw, "Annotations/"+smp.Name+".xml", append(out, '\n')); err != nil {
+			return err
+		}
+		fmt.Fprintln(lists[splitName(val[i])], smp.Name)
+	}
+	for _, split := range []string{"train", "val"} {
+		if err := writeEntry(zw, "ImageSets/Main/"+split+".txt", []byte(lists[split].String())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) copyImage(zw *zip.Writer, path, name string) error {
+	jpg, err := s.Image(name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	// JPEGs do not compress; storing them saves the effort
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: path, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(jpg)
+	return err
+}
+
+func writeEntry(zw *zip.Writer, path string, data []byte) error {
+	w, err := zw.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// inVal decides a sample's split from a hash of its name. Names of samples
+// taken together differ in a few characters only; a cryptographic hash
+// keeps even small datasets near the requested fraction.
+func inVal(name string, frac float64) bool {
+	sum := sha256.Sum256([]byte(name))
+	return float64(binary.BigEndian.Uint64(sum[:8])%10000) < frac*10000
+}
+
+func splitName(val bool) string {
+	if val {
+		return "val"
+	}
+	return "train"
+}
+
+func classes(samples []Sample) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, smp := range samples {
+		for _, b := range smp.Boxes {
+			if !seen[b.Label] {
+				seen[b.Label] = true
+				names = append(names, b.Label)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func classIndex(names []string) map[string]int {
+	m := make(map[string]int, len(names))
+	for i, n := range names {
+		m[n] = i
+	}
+	return m
+}
@@ -0,0 +1,198 @@
+package dataset
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"Garage48/internal/detector"
+)
+
+// testStore saves two samples: a 200×100 frame with a person and a car on
+// its top-left corner, and a 100×100 frame with a dog.
+func testStore(t *testing.T) (*Store, []Sample) {
+	t.Helper()
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	in := []Sample{
+		{Camera: "cam1", Time: t0, Seq: 1, Reason: ReasonManual, Width: 200, Height: 100, Boxes: []detector.Box{
+			{Label: "person", Conf: 0.9, X1: 20, Y1: 10, X2: 60, Y2: 90},
+			{Label: "car", Conf: 0.5, X1: 0, Y1: 0, X2: 100, Y2: 50},
+		}},
+		{Camera: "cam2", Time: t0.Add(time.Second), Seq: 2, Reason: ReasonRandom, Width: 100, Height: 100, Boxes: []detector.Box{
+			{Label: "dog", Conf: 0.7, X1: 10, Y1: 10, X2: 30, Y2: 30},
+		}},
+	}
+	var out []Sample
+	for i, smp := range in {
+		saved, err := s.Save([]byte(fmt.Sprintf("jpeg %d", i)), smp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, saved)
+	}
+	return s, out
+}
+
+func exportZip(t *testing.T, s *Store, opts ExportOptions) map[string][]byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := s.Export(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(r)
+		r.Close()
+		files[f.Name] = b
+	}
+	return files
+}
+
+func TestExport(t *testing.T) {
+	s, smp := testStore(t)
+	const val = 0.5
+	split := func(i int) string { return splitName(inVal(smp[i].Name, val)) }
+
+	tests := []struct {
+		format string
+		check  func(t *testing.T, files map[string][]byte)
+	}{
+		{FormatYOLO, func(t *testing.T, files map[string][]byte) {
+			// classes: car 0, dog 1, person 2
+			want := map[int]string{
+				0: "2 0.200000 0.500000 0.200000 0.800000\n0 0.250000 0.250000 0.500000 0.500000\n",
+				1: "1 0.200000 0.200000 0.200000 0.200000\n",
+			}
+			for i, lines := range want {
+				if got := string(files["labels/"+split(i)+"/"+smp[i].Name+".txt"]); got != lines {
+					t.Errorf("sample %d labels:\n%s\nwant:\n%s", i, got, lines)
+				}
+				if got := string(files["images/"+split(i)+"/"+smp[i].Name+".jpg"]); got != fmt.Sprintf("jpeg %d", i) {
+					t.Errorf("sample %d image %q", i, got)
+				}
+			}
+			wantYAML := "path: .\ntrain: images/train\nval: images/val\nnames:\n  0: \"car\"\n  1: \"dog\"\n  2: \"person\"\n"
+			if got := string(files["data.yaml"]); got != wantYAML {
+				t.Errorf("data.yaml:\n%s\nwant:\n%s", got, wantYAML)
+			}
+		}},
+		{FormatCOCO, func(t *testing.T, files map[string][]byte) {
+			var got []cocoAnnotation
+			for _, sp := range []string{"train", "val"} {
+				var f cocoFile
+				if err := json.Unmarshal(files["annotations/instances_"+sp+".json"], &f); err != nil {
+					t.Fatal(err)
+				}
+				if len(f.Categories) != 3 || f.Categories[0] != (cocoCategory{1, "car"}) || f.Categories[2] != (cocoCategory{3, "person"}) {
+					t.Errorf("%s categories %v", sp, f.Categories)
+				}
+				for _, a := range f.Annotations {
+					a.ID, a.ImageID = 0, 0 // numbered per split
+					got = append(got, a)
+				}
+			}
+			want := map[cocoAnnotation]bool{
+				{CategoryID: 3, BBox: [4]float64{20, 10, 40, 80}, Area: 3200}: true,
+				{CategoryID: 1, BBox: [4]float64{0, 0, 100, 50}, Area: 5000}:  true,
+				{CategoryID: 2, BBox: [4]float64{10, 10, 20, 20}, Area: 400}:  true,
+			}
+			if len(got) != len(want) {
+				t.Fatalf("got %d annotations, want %d", len(got), len(want))
+			}
+			for _, a := range got {
+				if !want[a] {
+					t.Errorf("unexpected annotation %+v", a)
+				}
+			}
+		}},
+		{FormatVOC, func(t *testing.T, files map[string][]byte) {
+			var a vocAnnotation
+			if err := xml.Unmarshal(files["Annotations/"+smp[0].Name+".xml"], &a); err != nil {
+				t.Fatal(err)
+			}
+			if a.Size != (vocSize{200, 100, 3}) || len(a.Objects) != 2 {
+				t.Fatalf("got %+v", a)
+			}
+			person, car := a.Objects[0], a.Objects[1]
+			if person.Name != "person" || person.BndBox.XMin != 21 || person.BndBox.YMin != 11 ||
+				person.BndBox.XMax != 60 || person.BndBox.YMax != 90 || person.Truncated != 0 {
+				t.Errorf("person %+v", person)
+			}
+			if car.BndBox.XMin != 1 || car.BndBox.YMin != 1 || car.Truncated != 1 {
+				t.Errorf("car at the frame edge %+v", car)
+			}
+			for i := range smp {
+				list := string(files["ImageSets/Main/"+split(i)+".txt"])
+				if !strings.Contains(list, smp[i].Name+"\n") {
+					t.Errorf("sample %d missing from %s list %q", i, split(i), list)
+				}
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			tt.check(t, exportZip(t, s, ExportOptions{Format: tt.format, Val: val}))
+		})
+	}
+}
+
+func TestExportSplitIsStable(t *testing.T) {
+	s, smp := testStore(t)
+	listed := func() string {
+		files := exportZip(t, s, ExportOptions{Format: FormatVOC, Val: 0.5})
+		return string(files["ImageSets/Main/train.txt"]) + "|" + string(files["ImageSets/Main/val.txt"])
+	}
+	first := listed()
+	if again := listed(); again != first {
+		t.Errorf("split changed between exports: %q then %q", first, again)
+	}
+	// a new sample does not move the existing ones
+	more := smp[0]
+	more.Time = more.Time.Add(time.Hour)
+	if _, err := s.Save([]byte("jpeg"), more); err != nil {
+		t.Fatal(err)
+	}
+	files := exportZip(t, s, ExportOptions{Format: FormatVOC, Val: 0.5})
+	for _, old := range smp {
+		sp := splitName(inVal(old.Name, 0.5))
+		if !strings.Contains(string(files["ImageSets/Main/"+sp+".txt"]), old.Name+"\n") {
+			t.Errorf("%s left the %s split", old.Name, sp)
+		}
+	}
+	for _, name := range []string{smp[0].Name, smp[1].Name, "cam_20260101T000000000_9"} {
+		if inVal(name, 0.5) != inVal(name, 0.5) || inVal(name, 0) || !inVal(name, 1) {
+			t.Errorf("inVal(%q) unstable or out of bounds", name)
+		}
+	}
+}
+
+func TestExportOptions(t *testing.T) {
+	s, _ := testStore(t)
+	for _, opts := range []ExportOptions{{Format: "darknet"}, {Format: FormatYOLO, Val: 1.5}} {
+		if err := s.Export(io.Discard, opts); err == nil {
+			t.Errorf("%+v: no error", opts)
+		}
+	}
+	files := exportZip(t, s, ExportOptions{Format: FormatVOC, Cameras: []string{"cam2"}})
+	if n := len(files); n != 2+2 { // one image, one annotation, two lists
+		t.Errorf("camera filter: %d files", n)
+	}
+}
@@ -0,0 +1,133 @@
+// Package dataset keeps camera frames with their detections as training
+// data and exports them in the layouts common training tools read.
+package dataset
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"Garage48/internal/detector"
+)
+
+// Reasons a sample was captured.
+const (
+	ReasonRandom  = "random"
+	ReasonLowConf = "low_conf" // a detection the model was unsure about
+	ReasonManual  = "manual"
+)
+
+// Sample is a saved frame and the detections on it, in its pixels. The
+// boxes are the model's guesses, to be reviewed before training.
+type Sample struct {
+	Name   string         `json:"name"` // file stem, unique in the store
+	Camera string         `json:"camera"`
+	Time   time.Time      `json:"time"`
+	Seq    uint64         `json:"seq"`
+	Reason string         `json:"reason"`
+	Width  int            `json:"width"`
+	Height int            `json:"height"`
+	Boxes  []detector.Box `json:"boxes"`
+}
+
+// Store keeps samples in a directory as NAME.jpg with NAME.json beside it.
+// The JSON file is written last, so a sample without one is incomplete and
+// ignored. Store is safe for concurrent use.
+type Store struct {
+	dir string
+}
+
+// ErrNotFound is returned for names the store does not hold.
+var ErrNotFound = errors.New("sample not found")
+
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Save writes jpg as a sample described by smp, whose Name is assigned
+// from the camera, time and sequence number. Saving the same frame again,
+// say by hand after it was sampled automatically, replaces its sample
+// rather than duplicating it.
+func (s *Store) Save(jpg []byte, smp Sample) (Sample, error) {
+	t := smp.Time.UTC()
+	smp.Name = fmt.Sprintf("%s_%s%03d_%d", safeName(smp.Camera), t.Format("20060102T150405"), t.Nanosecond()/1e6, smp.Seq)
+	boxes := make([]detector.Box, len(smp.Boxes))
+	for i, b := range smp.Boxes {
+		b.Norm = nil // pixels only; exports derive what they need
+		boxes[i] = b
+	}
+	smp.Boxes = boxes
+	meta, err := json.MarshalIndent(smp, "", "  ")
+	if err != nil {
+		return smp, err
+	}
+	if err := writeFile(filepath.Join(s.dir, smp.Name+".jpg"), jpg); err != nil {
+		return smp, err
+	}
+	if err := writeFile(filepath.Join(s.dir, smp.Name+".json"), meta); err != nil {
+		return smp, err
+	}
+	return smp, nil
+}
+
+// List returns every complete sample, oldest first.
+func (s *Store) List() ([]Sample, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Sample, 0, len(paths))
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		var smp Sample
+		if err := json.Unmarshal(b, &smp); err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(p), err)
+		}
+		out = append(out, smp)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out, nil
+}
+
+// Image returns the JPEG of the named sample.
+func (s *Store) Image(name string) ([]byte, error) {
+	if name == "" || name != safeName(name) {
+		return nil, ErrNotFound
+	}
+	b, err := os.ReadFile(filepath.Join(s.dir, name+".jpg"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+// writeFile replaces path atomically, so readers never see half a file.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// safeName keeps letters, digits, '-' and '_', replacing anything else.
+func safeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"mime/multipart"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,15 +20,28 @@ type Box struct {
 	Y1      int     `json:"y1"`
 	X2      int     `json:"x2"`
 	Y2      int     `json:"y2"`
+	TrackID int     `json:"track_id,omitempty"` // 0 when the backend does not track objects
+	// Norm is the box relative to the frame size. Backends may send it in
+	// place of pixel corners; NewResult fills those in.
+	Norm *Norm `json:"norm,omitempty"`
 }
 
 type Response struct {
 	Boxes []Box `json:"boxes"`
 }
 
+// BatchResponse is the reply of /detect_batch, one result per frame in
+// request order.
+type BatchResponse struct {
+	Results []Response `json:"results"`
+}
+
+// Client talks to the Python sidecar (detector_server.py), which takes the
+// frame as a multipart upload on /detect.
 type Client struct {
 	baseURL string
 	http    *http.Client
+	noBatch atomic.Bool // the sidecar predates /detect_batch
 }
 
 func New(baseURL string) *Client {
@@ -38,6 +53,11 @@ func New(baseURL string) *Client {
 	}
 }
 
+// Detect implements Detector.
+func (c *Client) Detect(ctx context.Context, jpeg []byte, conf, iou float64) ([]Box, error) {
+	return c.DetectJPEGCtx(ctx, jpeg, conf, iou)
+}
+
 // DetectJPEGCtx posts a JPEG frame with context (for tight timeouts).
 func (c *Client) DetectJPEGCtx(ctx context.Context, jpeg []byte, conf, iou float64) ([]Box, error) {
 	var body bytes.Buffer
@@ -63,6 +83,9 @@ func (c *Client) DetectJPEGCtx(ctx context.Context, jpeg []byte, conf, iou float
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("detector: %s", resp.Status)
+	}
 
 	var out Response
 	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
@@ -77,3 +100,103 @@ func (c *Client) DetectJPEG(jpeg []byte, conf, iou float64) ([]Box, error) {
 	defer cancel()
 	return c.DetectJPEGCtx(ctx, jpeg, conf, iou)
 }
+
+// DetectBatch implements BatchDetector with one /detect_batch upload of all
+// frames. Against a sidecar without that endpoint it falls back to
+// concurrent single-frame requests.
+func (c *Client) DetectBatch(ctx context.Context, jpegs [][]byte, conf, iou float64) ([][]Box, error) {
+	if c.noBatch.Load() {
+		return detectEach(ctx, c, jpegs, conf, iou)
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for i, jpg := range jpegs {
+		fw, err := w.CreateFormFile("files", fmt.Sprintf("frame%d.jpg", i))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write(jpg); err != nil {
+			return nil, err
+		}
+	}
+	_ = w.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "POST",
+		fmt.Sprintf("%s/detect_batch?conf=%g&iou=%g", c.baseURL, conf, iou), &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		c.noBatch.Store(true)
+		return detectEach(ctx, c, jpegs, conf, iou)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("detector: %s", resp.Status)
+	}
+	var out BatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if len(out.Results) != len(jpegs) {
+		return nil, fmt.Errorf("detector: %d results for %d frames", len(out.Results), len(jpegs))
+	}
+	boxes := make([][]Box, len(jpegs))
+	for i, r := range out.Results {
+		boxes[i] = r.Boxes
+	}
+	return boxes, nil
+}
+
+// detectEach runs a batch as concurrent single-frame calls.
+func detectEach(ctx context.Context, d Detector, jpegs [][]byte, conf, iou float64) ([][]Box, error) {
+	out := make([][]Box, len(jpegs))
+	errs := make([]error, len(jpegs))
+	var wg sync.WaitGroup
+	for i, jpg := range jpegs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out[i], errs[i] = d.Detect(ctx, jpg, conf, iou)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Health is the sidecar's /health report.
+type Health struct {
+	Backend string `json:"backend"`
+	Model   string `json:"model"`
+	ImgSize int    `json:"imgsz"`
+}
+
+// Health queries the sidecar's /health endpoint.
+func (c *Client) Health(ctx context.Context) (Health, error) {
+	var h Health
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/health", nil)
+	if err != nil {
+		return h, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return h, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return h, fmt.Errorf("detector: %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&h)
+	return h, err
+}
@@ -0,0 +1,93 @@
+package detector
+
+import (
+	"context"
+	"fmt"
+)
+
+// Detector finds objects in a JPEG frame. conf is the minimum confidence
+// and iou the non-maximum suppression overlap threshold; backends that do
+// their own filtering may ignore them. Implementations are safe for
+// concurrent use.
+type Detector interface {
+	Detect(ctx context.Context, jpeg []byte, conf, iou float64) ([]Box, error)
+}
+
+// InputSizer is implemented by detectors that know the image size their
+// model runs at, such as a Pool that has probed /health. InputSize returns
+// 0 while unknown.
+type InputSizer interface {
+	InputSize() int
+}
+
+// Backend kinds accepted in Config.Kind.
+const (
+	KindSidecar = "sidecar" // detector_server.py, multipart upload
+	KindJSON    = "json"    // JSON request with a base64 image
+	KindTCP     = "tcp"     // length-prefixed frames over a persistent connection
+	KindNoop    = "noop"    // never finds anything
+	KindReplay  = "replay"  // returns boxes recorded in a file
+	KindMotion  = "motion"  // built-in motion blobs, no model needed
+)
+
+// Config selects a camera's detector in config.json. The zero value is the
+// server-wide detector given on the command line.
+type Config struct {
+	Kind string `json:"kind"`
+	// URL is the endpoint for sidecar and json, or host:port for tcp.
+	// A sidecar without one is the server-wide detector.
+	URL string `json:"url"`
+	// File holds the recording for replay: JSON lines with a "boxes" array
+	// each, such as the output of cmd/framemeta.
+	File string `json:"file"`
+	// Motion tunes the motion detector.
+	Motion MotionConfig `json:"motion"`
+}
+
+// Validate checks that cfg names a known backend with what it needs.
+func (cfg Config) Validate() error {
+	switch cfg.Kind {
+	case "", KindSidecar, KindNoop, KindMotion:
+	case KindJSON, KindTCP:
+		if cfg.URL == "" {
+			return fmt.Errorf("detector %s needs a url", cfg.Kind)
+		}
+	case KindReplay:
+		if cfg.File == "" {
+			return fmt.Errorf("detector replay needs a file")
+		}
+	default:
+		return fmt.Errorf("unknown detector kind %q", cfg.Kind)
+	}
+	return nil
+}
+
+// Open builds the detector described by cfg. shared is the server-wide
+// detector, used when cfg does not name a sidecar of its own.
+func Open(cfg Config, shared Detector) (Detector, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.Kind {
+	case KindJSON:
+		return NewJSON(cfg.URL), nil
+	case KindTCP:
+		return NewTCP(cfg.URL), nil
+	case KindNoop:
+		return Noop{}, nil
+	case KindReplay:
+		return LoadReplay(cfg.File)
+	case KindMotion:
+		return NewMotion(cfg.Motion), nil
+	}
+	if cfg.URL != "" {
+		return New(cfg.URL), nil
+	}
+	return shared, nil
+}
+
+// Noop is a Detector that never finds anything, for cameras that only
+// stream.
+type Noop struct{}
+
+func (Noop) Detect(context.Context, []byte, float64, float64) ([]Box, error) { return nil, nil }
@@ -0,0 +1,56 @@
+package detector
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// JSONClient posts frames to a generic HTTP endpoint as
+//
+//	{"image": "<base64 JPEG>", "conf": 0.4, "iou": 0.45}
+//
+// and expects {"boxes": [...]} back, the shape of Response.
+type JSONClient struct {
+	url  string
+	http *http.Client
+}
+
+func NewJSON(url string) *JSONClient {
+	return &JSONClient{url: url, http: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type jsonRequest struct {
+	Image []byte  `json:"image"` // encoding/json writes []byte as base64
+	Conf  float64 `json:"conf"`
+	IOU   float64 `json:"iou"`
+}
+
+func (c *JSONClient) Detect(ctx context.Context, jpeg []byte, conf, iou float64) ([]Box, error) {
+	body := bytes.NewBuffer(make([]byte, 0, base64.StdEncoding.EncodedLen(len(jpeg))+64))
+	if err := json.NewEncoder(body).Encode(jsonRequest{Image: jpeg, Conf: conf, IOU: iou}); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("detector: %s", resp.Status)
+	}
+	var out Response
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return out.Boxes, nil
+}
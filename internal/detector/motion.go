@@ -0,0 +1,279 @@
+package detector
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"sync"
+
+	"Garage48/internal/jpegfast"
+)
+
+// Motion labels and class ID; no COCO class uses a negative ID.
+const (
+	MotionLabel   = "motion"
+	MotionClassID = -1
+)
+
+// MotionConfig tunes a Motion detector. Zero fields take the defaults noted.
+type MotionConfig struct {
+	// Threshold is the luma difference from the background that counts as
+	// change, default 25.
+	Threshold int `json:"threshold"`
+	// MinArea is the smallest blob reported, as a fraction of the frame,
+	// default 0.002.
+	MinArea float64 `json:"min_area"`
+	// Learn is how fast the background follows the scene per frame, 0 to 1,
+	// default 0.05. Changed areas are learned at a quarter of the rate, so
+	// an object that stops fades into the background.
+	Learn float64 `json:"learn"`
+}
+
+func (c MotionConfig) withDefaults() MotionConfig {
+	if c.Threshold <= 0 {
+		c.Threshold = 25
+	}
+	if c.MinArea <= 0 {
+		c.MinArea = 0.002
+	}
+	if c.Learn <= 0 || c.Learn > 1 {
+		c.Learn = 0.05
+	}
+	return c
+}
+
+// motionGridWidth bounds the width of the luma grid compared against the
+// background; moving objects are far larger than its cells.
+const motionGridWidth = 320
+
+// Motion is a Detector that needs no model: it keeps a running average of
+// the scene and reports blobs of changed pixels, found by connected
+// component labelling, as boxes labelled "motion". Conf is the share of a
+// box covered by change; conf and iou arguments are ignored. A Motion
+// learns one scene, so every camera needs its own.
+type Motion struct {
+	cfg MotionConfig
+
+	mu     sync.Mutex
+	bg     []float32 // background luma per grid cell, nil until the first frame
+	gw, gh int
+}
+
+func NewMotion(cfg MotionConfig) *Motion { return &Motion{cfg: cfg.withDefaults()} }
+
+func (m *Motion) Detect(_ context.Context, jpg []byte, _, _ float64) ([]Box, error) {
+	luma, gw, gh, w, h, err := motionLuma(jpg)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := gw * gh
+	if m.bg == nil || m.gw != gw || m.gh != gh {
+		m.reset(luma, gw, gh)
+		return nil, nil
+	}
+
+	mask := make([]bool, n)
+	changed := 0
+	thr := float32(m.cfg.Threshold)
+	learn := float32(m.cfg.Learn)
+	for i, v := range luma {
+		d := float32(v) - m.bg[i]
+		if d > thr || d < -thr {
+			mask[i] = true
+			changed++
+			m.bg[i] += learn / 4 * d
+		} else {
+			m.bg[i] += learn * d
+		}
+	}
+	// most of the picture changing is a light switch or a moved camera,
+	// not motion: start over from this frame
+	if changed > n/2 {
+		m.reset(luma, gw, gh)
+		return nil, nil
+	}
+	if changed == 0 {
+		return nil, nil
+	}
+
+	minCells := max(int(m.cfg.MinArea*float64(n)+0.5), 1)
+	var boxes []Box
+	for _, b := range motionBlobs(dilate(mask, gw, gh), mask, gw, gh) {
+		if b.changed < minCells {
+			continue
+		}
+		cells := b.r.Dx() * b.r.Dy()
+		boxes = append(boxes, Box{
+			Label:   MotionLabel,
+			ClassID: MotionClassID,
+			Conf:    float64(b.changed) / float64(cells),
+			X1:      b.r.Min.X * w / gw,
+			Y1:      b.r.Min.Y * h / gh,
+			X2:      (b.r.Max.X*w + gw - 1) / gw,
+			Y2:      (b.r.Max.Y*h + gh - 1) / gh,
+		})
+	}
+	return boxes, nil
+}
+
+func (m *Motion) reset(luma []uint8, gw, gh int) {
+	m.bg = make([]float32, len(luma))
+	for i, v := range luma {
+		m.bg[i] = float32(v)
+	}
+	m.gw, m.gh = gw, gh
+}
+
+// motionLuma decodes jpg to a luma grid at most motionGridWidth wide and
+// returns it with its size and the frame's.
+func motionLuma(jpg []byte) (luma []uint8, gw, gh, w, h int, err error) {
+	var img image.Image
+	if hdr, herr := jpegfast.DecodeHeader(jpg); herr == nil {
+		opts := jpegfast.DecodeOptions{Scale: jpegfast.ScaleFor(hdr.Width, motionGridWidth), Gray: true}
+		img, err = jpegfast.Decode(jpg, opts)
+		w, h = hdr.Width, hdr.Height
+	}
+	if img == nil {
+		if img, err = jpeg.Decode(bytes.NewReader(jpg)); err != nil {
+			return nil, 0, 0, 0, 0, err
+		}
+		w, h = img.Bounds().Dx(), img.Bounds().Dy()
+	}
+	var gray *image.Gray
+	switch m := img.(type) {
+	case *image.Gray:
+		gray = m
+	case *image.YCbCr:
+		gray = &image.Gray{Pix: m.Y, Stride: m.YStride, Rect: m.Rect}
+	default:
+		b := img.Bounds()
+		gray = image.NewGray(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				gray.SetGray(x, y, color.GrayModel.Convert(img.At(x, y)).(color.Gray))
+			}
+		}
+	}
+
+	// average k×k cells down to the grid
+	b := gray.Bounds()
+	k := (b.Dx() + motionGridWidth - 1) / motionGridWidth
+	gw, gh = (b.Dx()+k-1)/k, (b.Dy()+k-1)/k
+	luma = make([]uint8, gw*gh)
+	for gy := 0; gy < gh; gy++ {
+		for gx := 0; gx < gw; gx++ {
+			sum, cnt := 0, 0
+			for y := gy * k; y < min(gy*k+k, b.Dy()); y++ {
+				row := gray.Pix[y*gray.Stride:]
+				for x := gx * k; x < min(gx*k+k, b.Dx()); x++ {
+					sum += int(row[x])
+					cnt++
+				}
+			}
+			luma[gy*gw+gx] = uint8(sum / cnt)
+		}
+	}
+	return luma, gw, gh, w, h, nil
+}
+
+// dilate grows mask by one cell in every direction, joining the fragments
+// an object's uniform patches leave.
+func dilate(mask []bool, w, h int) []bool {
+	out := make([]bool, len(mask))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !mask[y*w+x] {
+				continue
+			}
+			for yy := max(y-1, 0); yy <= min(y+1, h-1); yy++ {
+				for xx := max(x-1, 0); xx <= min(x+1, w-1); xx++ {
+					out[yy*w+xx] = true
+				}
+			}
+		}
+	}
+	return out
+}
+
+type motionBlob struct {
+	r       image.Rectangle // bounds of the changed cells, in grid cells
+	changed int             // cells that changed before dilation
+}
+
+// motionBlobs labels the 8-connected components of mask in two passes with
+// union-find, bounding and counting the cells of orig in each. Dilation
+// only joins the blobs; it does not grow the boxes.
+func motionBlobs(mask, orig []bool, w, h int) []motionBlob {
+	labels := make([]int32, len(mask))
+	parent := []int32{0} // label 0 is background
+	find := func(l int32) int32 {
+		for parent[l] != l {
+			parent[l] = parent[parent[l]]
+			l = parent[l]
+		}
+		return l
+	}
+	union := func(a, b int32) int32 {
+		a, b = find(a), find(b)
+		if a < b {
+			parent[b] = a
+			return a
+		}
+		parent[a] = b
+		return b
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			if !mask[i] {
+				continue
+			}
+			var l int32
+			// neighbours already visited: W, NW, N, NE
+			for _, d := range [4][2]int{{-1, 0}, {-1, -1}, {0, -1}, {1, -1}} {
+				nx, ny := x+d[0], y+d[1]
+				if nx < 0 || ny < 0 || nx >= w {
+					continue
+				}
+				if nl := labels[ny*w+nx]; nl != 0 {
+					if l == 0 {
+						l = find(nl)
+					} else {
+						l = union(l, nl)
+					}
+				}
+			}
+			if l == 0 {
+				l = int32(len(parent))
+				parent = append(parent, l)
+			}
+			labels[i] = l
+		}
+	}
+
+	index := make(map[int32]int)
+	var blobs []motionBlob
+	for i, l := range labels {
+		if l == 0 {
+			continue
+		}
+		root := find(l)
+		j, ok := index[root]
+		x, y := i%w, i/w
+		cell := image.Rect(x, y, x+1, y+1)
+		if !ok {
+			j = len(blobs)
+			index[root] = j
+			blobs = append(blobs, motionBlob{})
+		}
+		if b := &blobs[j]; orig[i] {
+			b.r = b.r.Union(cell)
+			b.changed++
+		}
+	}
+	return blobs
+}
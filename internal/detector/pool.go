@@ -0,0 +1,363 @@
+package detector
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrNoDetector is returned by a Pool whose members are all ejected.
+var ErrNoDetector = errors.New("no detector available")
+
+// PoolConfig tunes a Pool. Zero fields take the defaults noted.
+type PoolConfig struct {
+	ProbeInterval time.Duration // between /health probes, default 5s
+	ProbeTimeout  time.Duration // default 2s
+	MaxFailures   int           // consecutive failures that open the breaker, default 3
+	Cooldown      time.Duration // before an ejected member gets a trial request, default 10s
+}
+
+func (c PoolConfig) withDefaults() PoolConfig {
+	if c.ProbeInterval <= 0 {
+		c.ProbeInterval = 5 * time.Second
+	}
+	if c.ProbeTimeout <= 0 {
+		c.ProbeTimeout = 2 * time.Second
+	}
+	if c.MaxFailures <= 0 {
+		c.MaxFailures = 3
+	}
+	if c.Cooldown <= 0 {
+		c.Cooldown = 10 * time.Second
+	}
+	return c
+}
+
+// Pool spreads requests over several sidecars. Each request goes to the
+// member with the lowest expected wait, its in-flight count times its
+// smoothed latency. A circuit breaker per member ejects it after
+// MaxFailures consecutive failures of requests or /health probes; after
+// Cooldown a single trial request (half-open) decides whether it returns.
+// A failed request is retried once on another member.
+type Pool struct {
+	cfg     PoolConfig
+	members []*member
+	now     func() time.Time
+	stop    context.CancelFunc
+	// chosen, if set, runs between choosing a member and reserving it;
+	// tests use it to let another caller in.
+	chosen func(*member)
+}
+
+// sidecar is what a Pool needs of a member; *Client in production.
+type sidecar interface {
+	BatchDetector
+	Health(ctx context.Context) (Health, error)
+}
+
+// Breaker states as reported in MemberStatus.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+type member struct {
+	url    string
+	client sidecar
+
+	mu       sync.Mutex
+	inflight int
+	latency  time.Duration // smoothed, 0 until the first success
+	state    string
+	fails    int // consecutive
+	openedAt time.Time
+	trial    bool // a half-open trial is in flight
+	health   Health
+	probedAt time.Time
+	lastErr  string
+	requests uint64
+	errors   uint64
+}
+
+// MemberStatus is a snapshot of one pool member for the status API.
+type MemberStatus struct {
+	URL       string    `json:"url"`
+	State     string    `json:"state"`
+	InFlight  int       `json:"in_flight"`
+	LatencyMS float64   `json:"latency_ms"`
+	Failures  int       `json:"consecutive_failures"`
+	Requests  uint64    `json:"requests"`
+	Errors    uint64    `json:"errors"`
+	LastError string    `json:"last_error,omitempty"`
+	Health    *Health   `json:"health,omitempty"`
+	ProbedAt  time.Time `json:"probed_at"`
+}
+
+// NewPool creates a pool over the sidecars at urls and starts probing
+// their /health endpoints until Close.
+func NewPool(urls []string, cfg PoolConfig) *Pool {
+	clients := make([]sidecar, len(urls))
+	for i, u := range urls {
+		clients[i] = New(u)
+	}
+	return newPool(urls, clients, cfg, time.Now)
+}
+
+// newPool is NewPool with a client per URL and a clock.
+func newPool(urls []string, clients []sidecar, cfg PoolConfig, now func() time.Time) *Pool {
+	p := &Pool{cfg: cfg.withDefaults(), now: now}
+	for i, u := range urls {
+		p.members = append(p.members, &member{url: u, client: clients[i], state: BreakerClosed})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stop = cancel
+	go p.probe(ctx)
+	return p
+}
+
+// Close stops the health probes.
+func (p *Pool) Close() { p.stop() }
+
+func (p *Pool) Detect(ctx context.Context, jpeg []byte, conf, iou float64) ([]Box, error) {
+	var boxes []Box
+	err := p.do(ctx, func(c sidecar) error {
+		var err error
+		boxes, err = c.Detect(ctx, jpeg, conf, iou)
+		return err
+	})
+	return boxes, err
+}
+
+func (p *Pool) DetectBatch(ctx context.Context, jpegs [][]byte, conf, iou float64) ([][]Box, error) {
+	var boxes [][]Box
+	err := p.do(ctx, func(c sidecar) error {
+		var err error
+		boxes, err = c.DetectBatch(ctx, jpegs, conf, iou)
+		return err
+	})
+	return boxes, err
+}
+
+// Health returns the /health report of the first member that has answered
+// a probe, for callers that need the model's properties.
+func (p *Pool) Health() (Health, bool) {
+	for _, m := range p.members {
+		m.mu.Lock()
+		h := m.health
+		m.mu.Unlock()
+		if h != (Health{}) {
+			return h, true
+		}
+	}
+	return Health{}, false
+}
+
+// InputSize implements InputSizer with the imgsz of Health.
+func (p *Pool) InputSize() int {
+	h, _ := p.Health()
+	return h.ImgSize
+}
+
+// do runs fn on the best member, then once more on another if it fails.
+func (p *Pool) do(ctx context.Context, fn func(sidecar) error) error {
+	var tried *member
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		m := p.pick(tried, p.now())
+		if m == nil {
+			break
+		}
+		start := p.now()
+		err = fn(m.client)
+		// a caller that gave up says nothing about the member
+		if errors.Is(err, context.Canceled) {
+			m.release()
+			return err
+		}
+		end := p.now()
+		m.done(err, end.Sub(start), p.cfg.MaxFailures, end)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		tried = m
+	}
+	if err == nil {
+		err = ErrNoDetector
+	}
+	return err
+}
+
+// pick reserves the member with the lowest expected wait, other than skip.
+// A member whose cooldown has passed is given the request as its trial.
+func (p *Pool) pick(skip *member, now time.Time) *member {
+	skipped := []*member{skip}
+	for {
+		var best *member
+		var bestCost time.Duration
+		for _, m := range p.members {
+			if slices.Contains(skipped, m) {
+				continue
+			}
+			m.mu.Lock()
+			cost, ok := m.cost(p.cfg.Cooldown, now)
+			m.mu.Unlock()
+			if ok && (best == nil || cost < bestCost) {
+				best, bestCost = m, cost
+			}
+		}
+		if best == nil {
+			return nil
+		}
+		if p.chosen != nil {
+			p.chosen(best)
+		}
+		if best.reserve(p.cfg.Cooldown, now) {
+			return best
+		}
+		// another caller took its trial meanwhile: try the next best
+		skipped = append(skipped, best)
+	}
+}
+
+// reserve claims m for a request if it may still be used.
+func (m *member) reserve(cooldown time.Duration, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.cost(cooldown, now); !ok {
+		return false
+	}
+	if m.state == BreakerOpen {
+		m.state, m.trial = BreakerHalfOpen, true
+	} else if m.state == BreakerHalfOpen {
+		m.trial = true
+	}
+	m.inflight++
+	return true
+}
+
+// cost returns the member's expected wait, or false if it must not be used.
+// Callers hold m.mu.
+func (m *member) cost(cooldown time.Duration, now time.Time) (time.Duration, bool) {
+	switch m.state {
+	case BreakerOpen:
+		if now.Sub(m.openedAt) < cooldown {
+			return 0, false
+		}
+	case BreakerHalfOpen:
+		if m.trial {
+			return 0, false
+		}
+	}
+	lat := m.latency
+	if lat == 0 {
+		lat = 100 * time.Millisecond // unknown: assume an ordinary model
+	}
+	return time.Duration(m.inflight+1) * lat, true
+}
+
+func (m *member) release() {
+	m.mu.Lock()
+	m.inflight--
+	m.trial = false
+	m.mu.Unlock()
+}
+
+// done records the outcome of a request reserved by pick.
+func (m *member) done(err error, took time.Duration, maxFails int, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inflight--
+	m.trial = false
+	m.requests++
+	if err == nil {
+		if m.latency == 0 {
+			m.latency = took
+		} else {
+			m.latency = (7*m.latency + took) / 8
+		}
+	} else {
+		m.errors++
+	}
+	m.record(err, maxFails, now)
+}
+
+// record advances the breaker. Callers hold m.mu.
+func (m *member) record(err error, maxFails int, now time.Time) {
+	if err == nil {
+		m.fails, m.lastErr, m.state = 0, "", BreakerClosed
+		return
+	}
+	m.fails++
+	m.lastErr = err.Error()
+	if m.state == BreakerHalfOpen || m.fails >= maxFails {
+		m.state, m.openedAt = BreakerOpen, now
+	}
+}
+
+func (p *Pool) probe(ctx context.Context) {
+	t := time.NewTicker(p.cfg.ProbeInterval)
+	defer t.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, m := range p.members {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				pctx, cancel := context.WithTimeout(ctx, p.cfg.ProbeTimeout)
+				h, err := m.client.Health(pctx)
+				cancel()
+				if ctx.Err() != nil {
+					return
+				}
+				now := p.now()
+				m.mu.Lock()
+				defer m.mu.Unlock()
+				m.probedAt = now
+				if err == nil {
+					m.health = h
+				}
+				// failed probes count against the breaker; a good one does not
+				// clear it, since /health can answer while /detect fails. An
+				// ejected member returns through its trial request.
+				if err != nil {
+					m.record(err, p.cfg.MaxFailures, now)
+				}
+			}()
+		}
+		wg.Wait()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Status reports every member in configuration order.
+func (p *Pool) Status() []MemberStatus {
+	out := make([]MemberStatus, 0, len(p.members))
+	for _, m := range p.members {
+		m.mu.Lock()
+		st := MemberStatus{
+			URL:       m.url,
+			State:     m.state,
+			InFlight:  m.inflight,
+			LatencyMS: float64(m.latency) / float64(time.Millisecond),
+			Failures:  m.fails,
+			Requests:  m.requests,
+			Errors:    m.errors,
+			LastError: m.lastErr,
+			ProbedAt:  m.probedAt,
+		}
+		if m.health != (Health{}) {
+			h := m.health
+			st.Health = &h
+		}
+		m.mu.Unlock()
+		out = append(out, st)
+	}
+	return out
+}
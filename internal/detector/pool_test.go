@@ -0,0 +1,235 @@
+package detector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+// fakeSidecar answers after took on the clock, failing with err if set.
+type fakeSidecar struct {
+	clock *fakeClock
+	took  time.Duration
+
+	mu  sync.Mutex
+	err error
+	n   int
+}
+
+func (f *fakeSidecar) setErr(err error) {
+	f.mu.Lock()
+	f.err = err
+	f.mu.Unlock()
+}
+
+func (f *fakeSidecar) calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.n
+}
+
+func (f *fakeSidecar) Detect(ctx context.Context, jpeg []byte, conf, iou float64) ([]Box, error) {
+	boxes, err := f.DetectBatch(ctx, [][]byte{jpeg}, conf, iou)
+	if err != nil {
+		return nil, err
+	}
+	return boxes[0], nil
+}
+
+func (f *fakeSidecar) DetectBatch(_ context.Context, jpegs [][]byte, _, _ float64) ([][]Box, error) {
+	f.mu.Lock()
+	f.n++
+	err := f.err
+	f.mu.Unlock()
+	f.clock.advance(f.took)
+	if err != nil {
+		return nil, err
+	}
+	return make([][]Box, len(jpegs)), nil
+}
+
+func (f *fakeSidecar) Health(context.Context) (Health, error) { return Health{ImgSize: 640}, nil }
+
+func newTestPool(t *testing.T, cfg PoolConfig, members ...*fakeSidecar) *Pool {
+	t.Helper()
+	urls := make([]string, len(members))
+	clients := make([]sidecar, len(members))
+	for i, m := range members {
+		urls[i], clients[i] = string(rune('a'+i)), m
+	}
+	p := newPool(urls, clients, cfg, members[0].clock.now)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func memberState(p *Pool, i int) string { return p.Status()[i].State }
+
+func TestPoolBreaker(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	errDown := errors.New("down")
+	// a is preferred while its latency is unknown (assumed 100ms)
+	a := &fakeSidecar{clock: clock, err: errDown}
+	b := &fakeSidecar{clock: clock, took: 200 * time.Millisecond}
+	p := newTestPool(t, PoolConfig{MaxFailures: 2, Cooldown: 10 * time.Second}, a, b)
+	detect := func() error { _, err := p.Detect(context.Background(), nil, 0, 0); return err }
+
+	steps := []struct {
+		name         string
+		advance      time.Duration
+		aErr         error
+		aCalls       int // total, after the step
+		state        string
+		wantNoMember bool
+	}{
+		{name: "first failure is retried on b", aErr: errDown, aCalls: 1, state: BreakerClosed},
+		{name: "second failure opens", aErr: errDown, aCalls: 2, state: BreakerOpen},
+		{name: "open member is skipped", aErr: errDown, aCalls: 2, state: BreakerOpen},
+		{name: "still cooling down", advance: 9 * time.Second, aErr: errDown, aCalls: 2, state: BreakerOpen},
+		{name: "failed trial reopens", advance: 2 * time.Second, aErr: errDown, aCalls: 3, state: BreakerOpen},
+		{name: "cooldown restarts at the trial", advance: 5 * time.Second, aCalls: 3, state: BreakerOpen},
+		{name: "good trial closes", advance: 6 * time.Second, aCalls: 4, state: BreakerClosed},
+		{name: "closed member serves again", aCalls: 5, state: BreakerClosed},
+	}
+	for _, st := range steps {
+		clock.advance(st.advance)
+		a.setErr(st.aErr)
+		if err := detect(); err != nil {
+			t.Fatalf("%s: %v", st.name, err)
+		}
+		if got := a.calls(); got != st.aCalls {
+			t.Errorf("%s: a called %d times, want %d", st.name, got, st.aCalls)
+		}
+		if got := memberState(p, 0); got != st.state {
+			t.Errorf("%s: a is %s, want %s", st.name, got, st.state)
+		}
+	}
+}
+
+func TestPoolHalfOpenSingleTrial(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	a := &fakeSidecar{clock: clock}
+	b := &fakeSidecar{clock: clock}
+	p := newTestPool(t, PoolConfig{MaxFailures: 1, Cooldown: time.Second}, a, b)
+	ma := p.members[0]
+	ma.mu.Lock()
+	ma.record(errors.New("down"), 1, clock.now())
+	ma.mu.Unlock()
+
+	clock.advance(time.Second)
+	if m := p.pick(nil, clock.now()); m != ma {
+		t.Fatal("cooled-down member not given the trial")
+	}
+	if got := memberState(p, 0); got != BreakerHalfOpen {
+		t.Errorf("state %s, want %s", got, BreakerHalfOpen)
+	}
+	// while the trial runs, everything else goes elsewhere
+	for i := 0; i < 3; i++ {
+		if m := p.pick(nil, clock.now()); m == ma {
+			t.Fatal("second request sent to a half-open member")
+		}
+	}
+	if m := p.pick(p.members[1], clock.now()); m != nil {
+		t.Error("pick returned a member with only the half-open one left")
+	}
+}
+
+func TestPoolAllEjected(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	errDown := errors.New("down")
+	a := &fakeSidecar{clock: clock, err: errDown}
+	b := &fakeSidecar{clock: clock, err: errDown}
+	p := newTestPool(t, PoolConfig{MaxFailures: 1}, a, b)
+	if _, err := p.Detect(context.Background(), nil, 0, 0); !errors.Is(err, errDown) {
+		t.Fatalf("got %v, want the member's error", err)
+	}
+	if _, err := p.Detect(context.Background(), nil, 0, 0); !errors.Is(err, ErrNoDetector) {
+		t.Fatalf("got %v, want ErrNoDetector", err)
+	}
+}
+
+func TestPoolCanceledDoesNotCount(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	a := &fakeSidecar{clock: clock, err: context.Canceled}
+	p := newTestPool(t, PoolConfig{MaxFailures: 1}, a)
+	p.Detect(context.Background(), nil, 0, 0)
+	if st := p.Status()[0]; st.State != BreakerClosed || st.InFlight != 0 || st.Failures != 0 {
+		t.Errorf("got %+v", st)
+	}
+}
+
+func TestPoolPicksLowestExpectedWait(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	p := newTestPool(t, PoolConfig{}, &fakeSidecar{clock: clock}, &fakeSidecar{clock: clock})
+	p.members[0].latency = 100 * time.Millisecond
+	p.members[1].latency = 300 * time.Millisecond
+	var got []string
+	for i := 0; i < 6; i++ {
+		got = append(got, p.pick(nil, clock.now()).url)
+	}
+	// a costs (inflight+1)×100ms, b (inflight+1)×300ms; ties keep the first
+	want := []string{"a", "a", "a", "b", "a", "a"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("picked %v, want %v", got, want)
+		}
+	}
+}
+
+// TestPoolLostTrialFallsBack has another caller take the trial of a
+// half-open member after pick chose it: pick must fall back to the healthy
+// member rather than return nothing.
+func TestPoolLostTrialFallsBack(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	p := newTestPool(t, PoolConfig{}, &fakeSidecar{clock: clock}, &fakeSidecar{clock: clock})
+	half, healthy := p.members[0], p.members[1]
+	half.state = BreakerHalfOpen
+	half.latency, healthy.latency = time.Millisecond, time.Second
+
+	p.chosen = func(m *member) {
+		if m == half {
+			p.chosen = nil
+			if other := p.pick(nil, clock.now()); other != half {
+				t.Fatalf("the other caller got %v, want the trial", other)
+			}
+		}
+	}
+	if m := p.pick(nil, clock.now()); m != healthy {
+		t.Fatalf("got %v, want the healthy member", m)
+	}
+	if st := p.Status(); st[0].InFlight != 1 || st[1].InFlight != 1 {
+		t.Errorf("in flight %d and %d, want 1 each", st[0].InFlight, st[1].InFlight)
+	}
+
+	// through Detect, the request succeeds on the healthy member
+	half.mu.Lock()
+	half.trial, half.inflight = false, 0
+	half.mu.Unlock()
+	p.chosen = func(m *member) {
+		if m == half {
+			p.chosen = nil
+			p.pick(nil, clock.now())
+		}
+	}
+	if _, err := p.Detect(context.Background(), nil, 0, 0); err != nil {
+		t.Errorf("Detect: %v", err)
+	}
+}
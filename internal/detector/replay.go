@@ -0,0 +1,64 @@
+package detector
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Replay is a Detector that ignores the frame and returns recorded results
+// in turn, starting over at the end. It reproduces a scene's detections
+// for testing overlays, alerts and filters without a model.
+type Replay struct {
+	mu     sync.Mutex
+	frames [][]Box
+	next   int
+}
+
+// NewReplay returns a Replay cycling through frames.
+func NewReplay(frames [][]Box) *Replay { return &Replay{frames: frames} }
+
+// LoadReplay reads a recording of JSON lines, each an object with a
+// "boxes" array; other fields are ignored, so cmd/framemeta output and
+// captured detector responses both work. Blank lines are skipped.
+func LoadReplay(path string) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var frames [][]Box
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var r Response
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		frames = append(frames, r.Boxes)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("%s: no recorded frames", path)
+	}
+	return NewReplay(frames), nil
+}
+
+func (r *Replay) Detect(context.Context, []byte, float64, float64) ([]Box, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.frames) == 0 {
+		return nil, nil
+	}
+	boxes := r.frames[r.next]
+	r.next = (r.next + 1) % len(r.frames)
+	return append([]Box(nil), boxes...), nil
+}
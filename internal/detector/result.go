@@ -0,0 +1,113 @@
+package detector
+
+import "math"
+
+// Norm holds box corners as fractions of the frame size, 0 at the top/left
+// edge and 1 at the bottom/right one. Backends may send boxes in this form
+// instead of pixels.
+type Norm struct {
+	X1 float64 `json:"x1"`
+	Y1 float64 `json:"y1"`
+	X2 float64 `json:"x2"`
+	Y2 float64 `json:"y2"`
+}
+
+// Frame identifies the image a detection ran on.
+type Frame struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Seq    uint64 `json:"seq"` // camera frame sequence number, 0 if unknown
+}
+
+// Result is one detection pass. Box pixels refer to Frame's size; frames
+// drawn on later may be of another size and are reached through Scale.
+type Result struct {
+	Frame
+	Boxes []Box `json:"boxes"`
+}
+
+// NewResult pairs boxes with the frame they were found on, converting
+// boxes that only have Norm to its pixels and clipping everything to its
+// bounds. Without a known frame size boxes are kept as they are.
+func NewResult(f Frame, boxes []Box) Result {
+	if f.Width <= 0 || f.Height <= 0 {
+		return Result{Frame: f, Boxes: boxes}
+	}
+	out := make([]Box, 0, len(boxes))
+	for _, b := range boxes {
+		if b.Norm != nil && b.X1 == 0 && b.Y1 == 0 && b.X2 == 0 && b.Y2 == 0 {
+			b = b.scaled(b.Norm.X1, b.Norm.Y1, b.Norm.X2, b.Norm.Y2, float64(f.Width), float64(f.Height))
+		}
+		b.Norm = nil // pixels are authoritative from here on; see Normalized
+		if b, ok := b.clip(f.Width, f.Height); ok {
+			out = append(out, b)
+		}
+	}
+	return Result{Frame: f, Boxes: out}
+}
+
+// Scale returns the boxes in the pixels of a w×h frame of the same scene,
+// clipped to it. Boxes that end up empty are dropped. The result is a new
+// slice; r is not modified.
+func (r Result) Scale(w, h int) []Box {
+	if len(r.Boxes) == 0 {
+		return nil
+	}
+	if r.Width <= 0 || r.Height <= 0 || (r.Width == w && r.Height == h) {
+		return append([]Box(nil), r.Boxes...)
+	}
+	sx, sy := float64(w)/float64(r.Width), float64(h)/float64(r.Height)
+	out := make([]Box, 0, len(r.Boxes))
+	for _, b := range r.Boxes {
+		b = b.scaled(float64(b.X1), float64(b.Y1), float64(b.X2), float64(b.Y2), sx, sy)
+		if b, ok := b.clip(w, h); ok {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// Normalized returns r with every box's Norm set.
+func (r Result) Normalized() Result {
+	if r.Width <= 0 || r.Height <= 0 {
+		return r
+	}
+	out := r
+	out.Boxes = make([]Box, len(r.Boxes))
+	fw, fh := float64(r.Width), float64(r.Height)
+	for i, b := range r.Boxes {
+		b.Norm = &Norm{
+			X1: float64(b.X1) / fw, Y1: float64(b.Y1) / fh,
+			X2: float64(b.X2) / fw, Y2: float64(b.Y2) / fh,
+		}
+		out.Boxes[i] = b
+	}
+	return out
+}
+
+// scaled sets b's pixel corners to the given ones multiplied by sx and sy,
+// rounding outward so a box never shrinks below what was detected. The
+// slack keeps float error from growing exact corners by a pixel.
+func (b Box) scaled(x1, y1, x2, y2, sx, sy float64) Box {
+	const slack = 1e-6
+	b.X1 = int(math.Floor(x1*sx + slack))
+	b.Y1 = int(math.Floor(y1*sy + slack))
+	b.X2 = int(math.Ceil(x2*sx - slack))
+	b.Y2 = int(math.Ceil(y2*sy - slack))
+	b.Norm = nil
+	return b
+}
+
+// clip limits b to a w×h frame and orders its corners. It reports false if
+// nothing is left.
+func (b Box) clip(w, h int) (Box, bool) {
+	if b.X1 > b.X2 {
+		b.X1, b.X2 = b.X2, b.X1
+	}
+	if b.Y1 > b.Y2 {
+		b.Y1, b.Y2 = b.Y2, b.Y1
+	}
+	b.X1, b.X2 = min(max(b.X1, 0), w), min(max(b.X2, 0), w)
+	b.Y1, b.Y2 = min(max(b.Y1, 0), h), min(max(b.Y2, 0), h)
+	return b, b.X2 > b.X1 && b.Y2 > b.Y1
+}
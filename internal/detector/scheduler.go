@@ -0,0 +1,184 @@
+package detector
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BatchDetector is a Detector that can process several frames in one call.
+// Results are returned in the order of jpegs.
+type BatchDetector interface {
+	Detector
+	DetectBatch(ctx context.Context, jpegs [][]byte, conf, iou float64) ([][]Box, error)
+}
+
+// Thresholds used by cameras unless configured otherwise.
+const (
+	DefaultConf = 0.4
+	DefaultIOU  = 0.45
+)
+
+// SchedulerConfig tunes a Scheduler. Zero fields take the defaults noted.
+type SchedulerConfig struct {
+	MaxBatch int           // frames per request, default 8
+	Interval time.Duration // minimum time between request starts, default 150ms
+	Timeout  time.Duration // per request, default 1s
+	Conf     float64       // default DefaultConf
+	IOU      float64       // default DefaultIOU
+}
+
+func (c SchedulerConfig) withDefaults() SchedulerConfig {
+	if c.MaxBatch <= 0 {
+		c.MaxBatch = 8
+	}
+	if c.Interval <= 0 {
+		c.Interval = 150 * time.Millisecond
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.Conf == 0 {
+		c.Conf = DefaultConf
+	}
+	if c.IOU == 0 {
+		c.IOU = DefaultIOU
+	}
+	return c
+}
+
+// Scheduler funnels the frames of many cameras into batched requests to
+// one detector. Each camera holds at most one pending frame, replaced by
+// newer ones, so a slow detector always sees the latest picture. When more
+// cameras are waiting than fit in a batch they are served round-robin.
+type Scheduler struct {
+	det BatchDetector
+	cfg SchedulerConfig
+
+	mu      sync.Mutex
+	sources []*schedSource
+	next    int // index where the next batch starts looking
+	wake    chan struct{}
+}
+
+type schedSource struct {
+	frame   []byte             // pending, nil when none
+	deliver func([]Box, error) // of the pending frame
+}
+
+func NewScheduler(det BatchDetector, cfg SchedulerConfig) *Scheduler {
+	return &Scheduler{det: det, cfg: cfg.withDefaults(), wake: make(chan struct{}, 1)}
+}
+
+// Register adds a camera. Every frame passed to submit replaces the
+// camera's pending one; if it makes it into a batch, its deliver receives
+// the result. deliver runs on the scheduler's goroutine and must not
+// block. unregister drops the camera and any pending frame.
+func (s *Scheduler) Register() (submit func(jpeg []byte, deliver func([]Box, error)), unregister func()) {
+	src := &schedSource{}
+	s.mu.Lock()
+	s.sources = append(s.sources, src)
+	s.mu.Unlock()
+
+	submit = func(jpeg []byte, deliver func([]Box, error)) {
+		s.mu.Lock()
+		src.frame, src.deliver = jpeg, deliver
+		s.mu.Unlock()
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	unregister = func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, o := range s.sources {
+			if o == src {
+				s.sources = append(s.sources[:i], s.sources[i+1:]...)
+				if s.next > i {
+					s.next--
+				}
+				break
+			}
+		}
+	}
+	return submit, unregister
+}
+
+// take removes up to MaxBatch pending frames, starting where the previous
+// batch stopped.
+func (s *Scheduler) take() ([]func([]Box, error), [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var delivers []func([]Box, error)
+	var frames [][]byte
+	n := len(s.sources)
+	for k := 0; k < n && len(delivers) < s.cfg.MaxBatch; k++ {
+		i := (s.next + k) % n
+		src := s.sources[i]
+		if src.frame == nil {
+			continue
+		}
+		delivers = append(delivers, src.deliver)
+		frames = append(frames, src.frame)
+		src.frame = nil
+		if len(delivers) == s.cfg.MaxBatch {
+			s.next = (i + 1) % n
+		}
+	}
+	return delivers, frames
+}
+
+// Run sends batches until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	var last time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		}
+		if wait := s.cfg.Interval - time.Since(last); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+		delivers, frames := s.take()
+		if len(delivers) == 0 {
+			continue
+		}
+		last = time.Now()
+		dctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+		boxes, err := s.det.DetectBatch(dctx, frames, s.cfg.Conf, s.cfg.IOU)
+		cancel()
+		for i, deliver := range delivers {
+			if err != nil {
+				deliver(nil, err)
+			} else {
+				deliver(boxes[i], nil)
+			}
+		}
+		// frames submitted during the request are waiting
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Batched returns d as a BatchDetector, running the frames of a batch as
+// concurrent single calls if d has no batch support of its own.
+func Batched(d Detector) BatchDetector {
+	if b, ok := d.(BatchDetector); ok {
+		return b
+	}
+	return batched{d}
+}
+
+type batched struct{ Detector }
+
+func (b batched) DetectBatch(ctx context.Context, jpegs [][]byte, conf, iou float64) ([][]Box, error) {
+	return detectEach(ctx, b.Detector, jpegs, conf, iou)
+}
@@ -0,0 +1,158 @@
+package detector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSchedulerRoundRobin checks that when more cameras wait than fit in a
+// batch, every camera is served before any is served twice.
+func TestSchedulerRoundRobin(t *testing.T) {
+	s := NewScheduler(Batched(Noop{}), SchedulerConfig{MaxBatch: 2})
+	var submits []func([]byte, func([]Box, error))
+	for i := 0; i < 5; i++ {
+		submit, _ := s.Register()
+		submits = append(submits, submit)
+	}
+	fill := func() {
+		for i, submit := range submits {
+			submit([]byte{byte(i)}, func([]Box, error) {})
+		}
+	}
+	served := make([]int, len(submits))
+	var order []byte
+	fill()
+	for round := 0; round < 10; round++ {
+		_, frames := s.take()
+		if len(frames) != 2 {
+			t.Fatalf("round %d: batch of %d, want 2", round, len(frames))
+		}
+		for _, f := range frames {
+			served[f[0]]++
+			order = append(order, f[0])
+		}
+		fill() // every camera always has a newer frame waiting
+	}
+	for i, n := range served {
+		if n != 4 {
+			t.Errorf("camera %d served %d times in %v, want 4", i, n, order)
+		}
+	}
+}
+
+func TestSchedulerSkipsIdleAndUnregistered(t *testing.T) {
+	s := NewScheduler(Batched(Noop{}), SchedulerConfig{MaxBatch: 2})
+	var submits []func([]byte, func([]Box, error))
+	var unregs []func()
+	for i := 0; i < 4; i++ {
+		submit, unreg := s.Register()
+		submits, unregs = append(submits, submit), append(unregs, unreg)
+	}
+	nop := func([]Box, error) {}
+	submits[1]([]byte{1}, nop)
+	submits[3]([]byte{3}, nop)
+	if _, frames := s.take(); len(frames) != 2 || frames[0][0] != 1 || frames[1][0] != 3 {
+		t.Fatalf("got %v, want the two pending frames", frames)
+	}
+
+	submits[0]([]byte{0}, nop)
+	submits[2]([]byte{2}, nop)
+	unregs[0]()
+	if _, frames := s.take(); len(frames) != 1 || frames[0][0] != 2 {
+		t.Fatalf("got %v, want only camera 2", frames)
+	}
+}
+
+type fakeBatcher struct {
+	mu      sync.Mutex
+	batches [][]string
+	err     error
+}
+
+func (f *fakeBatcher) Detect(ctx context.Context, jpeg []byte, conf, iou float64) ([]Box, error) {
+	return nil, errors.New("unused")
+}
+
+func (f *fakeBatcher) DetectBatch(_ context.Context, jpegs [][]byte, _, _ float64) ([][]Box, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	out := make([][]Box, len(jpegs))
+	for i, j := range jpegs {
+		names = append(names, string(j))
+		out[i] = []Box{{Label: string(j)}}
+	}
+	f.batches = append(f.batches, names)
+	return out, f.err
+}
+
+func TestSchedulerRun(t *testing.T) {
+	det := &fakeBatcher{}
+	s := NewScheduler(det, SchedulerConfig{MaxBatch: 8, Interval: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type result struct {
+		label string
+		err   error
+	}
+	results := make(chan result, 10)
+	deliver := func(boxes []Box, err error) {
+		r := result{err: err}
+		if len(boxes) > 0 {
+			r.label = boxes[0].Label
+		}
+		results <- r
+	}
+	dropped := func([]Box, error) { t.Error("replaced frame delivered") }
+
+	var submits []func([]byte, func([]Box, error))
+	for i := 0; i < 3; i++ {
+		submit, _ := s.Register()
+		submits = append(submits, submit)
+	}
+	// before Run starts, camera 0 replaces its pending frame
+	submits[0]([]byte("old"), dropped)
+	submits[0]([]byte("cam0"), deliver)
+	submits[1]([]byte("cam1"), deliver)
+	go s.Run(ctx)
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-results:
+			if r.err != nil {
+				t.Fatal(r.err)
+			}
+			got[r.label] = true
+		case <-time.After(2 * time.Second):
+			t.Fatal("no result")
+		}
+	}
+	if !got["cam0"] || !got["cam1"] {
+		t.Errorf("delivered %v", got)
+	}
+
+	det.mu.Lock()
+	det.err = errors.New("sidecar down")
+	det.mu.Unlock()
+	submits[2]([]byte("cam2"), deliver)
+	select {
+	case r := <-results:
+		if r.err == nil || r.label != "" {
+			t.Errorf("got %+v, want the batch error", r)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no result")
+	}
+
+	det.mu.Lock()
+	defer det.mu.Unlock()
+	if fmt.Sprint(det.batches[0]) != "[cam0 cam1]" {
+		t.Errorf("first batch %v, want [cam0 cam1]", det.batches[0])
+	}
+}
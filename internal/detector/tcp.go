@@ -0,0 +1,158 @@
+package detector
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// TCPClient speaks a gRPC-style framed protocol over persistent TCP
+// connections. Every message is a frame of
+//
+//	1 byte   flags, 0
+//	4 bytes  big-endian payload length
+//	payload
+//
+// A request is two frames: JSON parameters {"conf": 0.4, "iou": 0.45},
+// then the JPEG bytes. The reply is one frame of JSON, {"boxes": [...]} or
+// {"error": "..."}. A connection carries one request at a time; concurrent
+// calls use separate connections.
+type TCPClient struct {
+	addr string
+
+	mu   sync.Mutex
+	idle []*tcpConn
+}
+
+type tcpConn struct {
+	c net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+const (
+	tcpMaxIdle  = 4
+	tcpMaxReply = 16 << 20
+)
+
+func NewTCP(addr string) *TCPClient { return &TCPClient{addr: addr} }
+
+type tcpParams struct {
+	Conf float64 `json:"conf"`
+	IOU  float64 `json:"iou"`
+}
+
+type tcpReply struct {
+	Boxes []Box  `json:"boxes"`
+	Error string `json:"error"`
+}
+
+func (c *TCPClient) Detect(ctx context.Context, jpeg []byte, conf, iou float64) ([]Box, error) {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(10 * time.Second)
+	}
+	_ = conn.c.SetDeadline(deadline)
+	// a cancelled context unblocks the exchange through the deadline
+	stop := context.AfterFunc(ctx, func() { _ = conn.c.SetDeadline(time.Now()) })
+	defer stop()
+
+	reply, err := conn.exchange(jpeg, conf, iou)
+	if err != nil {
+		conn.c.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	if !stop() {
+		// the context fired after the reply; the deadline is spoiled
+		conn.c.Close()
+	} else {
+		c.put(conn)
+	}
+	if reply.Error != "" {
+		return nil, fmt.Errorf("detector: %s", reply.Error)
+	}
+	return reply.Boxes, nil
+}
+
+func (conn *tcpConn) exchange(jpeg []byte, conf, iou float64) (tcpReply, error) {
+	var reply tcpReply
+	params, err := json.Marshal(tcpParams{Conf: conf, IOU: iou})
+	if err != nil {
+		return reply, err
+	}
+	writeFrame(conn.w, params)
+	writeFrame(conn.w, jpeg)
+	if err := conn.w.Flush(); err != nil {
+		return reply, err
+	}
+	payload, err := readFrame(conn.r)
+	if err != nil {
+		return reply, err
+	}
+	return reply, json.Unmarshal(payload, &reply)
+}
+
+func writeFrame(w *bufio.Writer, payload []byte) {
+	var hdr [5]byte
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(payload)))
+	_, _ = w.Write(hdr[:])
+	_, _ = w.Write(payload) // errors resurface on Flush
+}
+
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[0] != 0 {
+		return nil, errors.New("detector: compressed frames are not supported")
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > tcpMaxReply {
+		return nil, fmt.Errorf("detector: reply of %d bytes is too large", n)
+	}
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	return buf, err
+}
+
+func (c *TCPClient) get(ctx context.Context) (*tcpConn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return conn, nil
+	}
+	c.mu.Unlock()
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpConn{c: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}, nil
+}
+
+func (c *TCPClient) put(conn *tcpConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle) >= tcpMaxIdle {
+		conn.c.Close()
+		return
+	}
+	c.idle = append(c.idle, conn)
+}
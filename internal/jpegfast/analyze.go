@@ -0,0 +1,282 @@
+package jpegfast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Info describes a JPEG as read from its markers, without decoding any
+// entropy-coded data.
+type Info struct {
+	Width, Height int
+	Components    int
+	// Sampling names the chroma layout: "4:4:4", "4:2:2", "4:2:0", "4:4:0",
+	// "4:1:1", "4:1:0", "gray", or the raw factors such as "2x2,1x1,1x1".
+	Sampling    string
+	Progressive bool
+	// Quality is the IJG quality (1..100) whose scaled luminance table is
+	// closest to the file's, or 0 without a luminance table.
+	Quality int
+	// StandardTables reports that the luminance table matches the scaled
+	// Annex K table exactly, so Quality is what the encoder was set to.
+	StandardTables bool
+	Restart        int // restart interval in MCUs, 0 for none
+	Orientation    int // EXIF orientation 1..8, 0 without EXIF
+	Scans          int
+	Size           int // bytes up to and including EOI
+}
+
+// Analyze walks the markers of data and reports what it finds. It returns
+// an error wrapping ErrFormat for anything a decoder would trip over: a
+// missing SOI, SOF or SOS, a segment running past the end, a Huffman table
+// over-subscribing its code space, or entropy data not terminated by EOI,
+// as when a frame is cut short in transit. The returned Info holds
+// whatever was parsed before the error.
+func Analyze(data []byte) (Info, error) {
+	var info Info
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return info, formatError("missing SOI marker")
+	}
+	var (
+		quant   [4][64]uint16
+		hasQ    [4]bool
+		lumaTq  = -1
+		factors [3][2]int
+	)
+	pos := 2
+	for {
+		for pos < len(data) && data[pos] != 0xFF {
+			pos++
+		}
+		for pos < len(data) && data[pos] == 0xFF {
+			pos++
+		}
+		if pos >= len(data) {
+			if info.Scans > 0 {
+				return info, formatError("truncated image data: missing EOI")
+			}
+			return info, formatError("missing SOS marker")
+		}
+		m := data[pos]
+		pos++
+		if m >= 0xD0 && m <= 0xD7 || m == 0x01 {
+			continue
+		}
+		if m == 0xD8 {
+			return info, formatError("unexpected SOI marker")
+		}
+		if m == 0xD9 {
+			if info.Scans == 0 {
+				return info, formatError("EOI before image data")
+			}
+			info.Size = pos
+			break
+		}
+		if pos+2 > len(data) {
+			return info, formatError("truncated segment")
+		}
+		n := int(data[pos])<<8 | int(data[pos+1])
+		if n < 2 || pos+n > len(data) {
+			return info, formatError("truncated segment")
+		}
+		seg := data[pos+2 : pos+n]
+		pos += n
+
+		switch m {
+		case 0xC0, 0xC1, 0xC2, 0xC3, 0xC5, 0xC6, 0xC7, 0xC9, 0xCA, 0xCB, 0xCD, 0xCE, 0xCF:
+			if info.Components != 0 {
+				return info, formatError("multiple SOF markers")
+			}
+			if len(seg) < 6 {
+				return info, formatError("bad SOF segment")
+			}
+			info.Progressive = m == 0xC2 || m == 0xC6 || m == 0xCA || m == 0xCE
+			info.Height = int(seg[1])<<8 | int(seg[2])
+			info.Width = int(seg[3])<<8 | int(seg[4])
+			info.Components = int(seg[5])
+			if info.Components == 0 || len(seg) < 6+3*info.Components {
+				return info, formatError("bad SOF segment")
+			}
+			for i := 0; i < info.Components; i++ {
+				c := seg[6+3*i:]
+				if i < len(factors) {
+					factors[i] = [2]int{int(c[1] >> 4), int(c[1] & 15)}
+				}
+				if i == 0 {
+					lumaTq = int(c[2] & 3)
+				}
+			}
+			info.Sampling = samplingName(info.Components, factors)
+		case 0xDB:
+			for len(seg) > 0 {
+				wide, t := seg[0]>>4 != 0, seg[0]&3
+				size := 65
+				if wide {
+					size = 129
+				}
+				if len(seg) < size {
+					return info, formatError("bad DQT segment")
+				}
+				for k := 0; k < 64; k++ {
+					if wide {
+						quant[t][zigzag[k]] = uint16(seg[1+2*k])<<8 | uint16(seg[2+2*k])
+					} else {
+						quant[t][zigzag[k]] = uint16(seg[1+k])
+					}
+				}
+				hasQ[t] = true
+				seg = seg[size:]
+			}
+		case 0xC4:
+			// a table over-subscribing its code space cannot be decoded
+			for len(seg) > 0 {
+				if len(seg) < 17 || seg[0]>>4 > 1 || seg[0]&15 > 3 {
+					return info, formatError("bad DHT segment")
+				}
+				var counts [16]uint8
+				copy(counts[:], seg[1:17])
+				if err := checkHuffCounts(&counts); err != nil {
+					return info, err
+				}
+				total := 0
+				for _, c := range counts {
+					total += int(c)
+				}
+				if len(seg) < 17+total {
+					return info, formatError("bad DHT segment")
+				}
+				seg = seg[17+total:]
+			}
+		case 0xDD:
+			if len(seg) < 2 {
+				return info, formatError("bad DRI segment")
+			}
+			info.Restart = int(seg[0])<<8 | int(seg[1])
+		case 0xE1:
+			if o := exifOrientation(seg); o != 0 {
+				info.Orientation = o
+			}
+		case 0xDA:
+			if info.Components == 0 {
+				return info, formatError("SOS before SOF")
+			}
+			info.Scans++
+			pos = skipEntropy(data, pos)
+		}
+	}
+	if lumaTq >= 0 && hasQ[lumaTq] {
+		info.Quality, info.StandardTables = estimateQuality(&quant[lumaTq])
+	}
+	if info.Width == 0 || info.Height == 0 {
+		return info, formatError("zero image dimensions")
+	}
+	return info, nil
+}
+
+// skipEntropy returns the position of the first marker after the
+// entropy-coded data starting at pos, stepping over stuffed zero bytes and
+// restart markers; len(data) if there is none.
+func skipEntropy(data []byte, pos int) int {
+	for {
+		i := bytes.IndexByte(data[pos:], 0xFF)
+		if i < 0 || pos+i+1 >= len(data) {
+			return len(data)
+		}
+		pos += i + 1
+		if m := data[pos]; m != 0 && m != 0xFF && (m < 0xD0 || m > 0xD7) {
+			return pos - 1
+		}
+	}
+}
+
+func samplingName(ncomp int, f [3][2]int) string {
+	if ncomp == 1 {
+		return "gray"
+	}
+	if ncomp == 3 && f[1] == [2]int{1, 1} && f[2] == [2]int{1, 1} {
+		switch f[0] {
+		case [2]int{1, 1}:
+			return "4:4:4"
+		case [2]int{2, 1}:
+			return "4:2:2"
+		case [2]int{2, 2}:
+			return "4:2:0"
+		case [2]int{1, 2}:
+			return "4:4:0"
+		case [2]int{4, 1}:
+			return "4:1:1"
+		case [2]int{4, 2}:
+			return "4:1:0"
+		}
+	}
+	var b []byte
+	for i := 0; i < min(ncomp, len(f)); i++ {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = fmt.Appendf(b, "%dx%d", f[i][0], f[i][1])
+	}
+	return string(b)
+}
+
+// estimateQuality finds the IJG quality whose scaled Annex K luminance
+// table is nearest to q in summed absolute difference.
+func estimateQuality(q *[64]uint16) (quality int, exact bool) {
+	best := -1
+	for n := 1; n <= 100; n++ {
+		s := scaleQuant(&baseQuant[0], n)
+		d := 0
+		for i := range s {
+			d += max(int(s[i])-int(q[i]), int(q[i])-int(s[i]))
+		}
+		if best < 0 || d < best {
+			best, quality = d, n
+		}
+	}
+	return quality, best == 0
+}
+
+// exifOrientation returns the Orientation tag of IFD0 in an APP1 EXIF
+// segment, or 0 if the segment is not EXIF or has none.
+func exifOrientation(seg []byte) int {
+	if len(seg) < 14 || string(seg[:6]) != "Exif\x00\x00" {
+		return 0
+	}
+	tiff := seg[6:]
+	var bo binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return 0
+	}
+	if bo.Uint16(tiff[2:]) != 42 {
+		return 0
+	}
+	ifd := int(bo.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	n := int(bo.Uint16(tiff[ifd:]))
+	for i := 0; i < n; i++ {
+		e := ifd + 2 + 12*i
+		if e+12 > len(tiff) {
+			return 0
+		}
+		if bo.Uint16(tiff[e:]) != 0x0112 {
+			continue
+		}
+		// SHORT, count 1: the value sits left-justified in the offset field
+		if bo.Uint16(tiff[e+2:]) != 3 {
+			return 0
+		}
+		if o := int(bo.Uint16(tiff[e+8:])); o >= 1 && o <= 8 {
+			return o
+		}
+		return 0
+	}
+	return 0
+}
@@ -0,0 +1,31 @@
+package jpegfast
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	good := encodeTest(t, testImage(64, 48), EncodeConfig{Quality: 80, Subsampling: Subsample420})
+	info, err := Analyze(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Width != 64 || info.Height != 48 || info.Sampling != "4:2:0" || info.Quality != 80 || !info.StandardTables {
+		t.Errorf("got %+v", info)
+	}
+	if info.Size != len(good) {
+		t.Errorf("size %d, want %d", info.Size, len(good))
+	}
+
+	bad := map[string][]byte{
+		"over-subscribed DHT": overSubscribeDHT(t, good),
+		"truncated":           good[:len(good)-10],
+		"no SOI":              good[2:],
+	}
+	for name, data := range bad {
+		if _, err := Analyze(data); !errors.Is(err, ErrFormat) {
+			t.Errorf("%s: got %v, want ErrFormat", name, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package jpegfast
+
+import "sync"
+
+// coefImage is a baseline JPEG held as quantized DCT coefficients, the
+// working form for edits that must not go through pixels.
+type coefImage struct {
+	width, height int
+	ncomp         int
+	hmax, vmax    int
+	mcusX, mcusY  int
+	comp          [3]coefPlane
+	quant         [4][64]int32 // natural order
+}
+
+// coefPlane holds one component's blocks, padded to whole MCUs.
+type coefPlane struct {
+	h, v   int
+	tq     uint8
+	bw, bh int
+	blocks [][64]int32
+}
+
+func (p *coefPlane) block(bx, by int) *[64]int32 { return &p.blocks[by*p.bw+bx] }
+
+var coefPool = sync.Pool{New: func() any { return new(coefImage) }}
+
+// setSize lays out the planes for a width x height frame whose component
+// sampling factors are already set, reusing block storage.
+func (ci *coefImage) setSize(width, height int) {
+	ci.width, ci.height = width, height
+	ci.hmax, ci.vmax = 1, 1
+	for i := 0; i < ci.ncomp; i++ {
+		ci.hmax, ci.vmax = max(ci.hmax, ci.comp[i].h), max(ci.vmax, ci.comp[i].v)
+	}
+	ci.mcusX = (width + 8*ci.hmax - 1) / (8 * ci.hmax)
+	ci.mcusY = (height + 8*ci.vmax - 1) / (8 * ci.vmax)
+	for i := 0; i < ci.ncomp; i++ {
+		p := &ci.comp[i]
+		p.bw, p.bh = ci.mcusX*p.h, ci.mcusY*p.v
+		n := p.bw * p.bh
+		if cap(p.blocks) < n {
+			p.blocks = make([][64]int32, n)
+		}
+		p.blocks = p.blocks[:n]
+	}
+}
+
+// readCoefficients entropy-decodes the parsed scan of d into ci.
+func (d *decoder) readCoefficients(ci *coefImage) error {
+	ci.ncomp = d.ncomp
+	ci.quant = d.quant
+	for i := 0; i < d.ncomp; i++ {
+		ci.comp[i].h, ci.comp[i].v, ci.comp[i].tq = d.comp[i].h, d.comp[i].v, d.comp[i].tq
+	}
+	ci.setSize(d.width, d.height)
+	return d.scan(true, func(i, bx, by int, b *[64]int32, _ bool) {
+		*ci.comp[i].block(bx, by) = *b
+	})
+}
+
+// encode writes ci as a baseline JFIF with its own quantization tables and
+// the standard Huffman tables, which can code any coefficient values.
+func (ci *coefImage) encode(w *bitWriter) {
+	w.writeBytes(jfifHeader...)
+
+	// DQT for every table in use, 16-bit entries only if needed
+	sof := byte(0xC0)
+	var used [4]bool
+	for i := 0; i < ci.ncomp; i++ {
+		used[ci.comp[i].tq] = true
+	}
+	for t, ok := range used {
+		if !ok {
+			continue
+		}
+		wide := false
+		for _, v := range ci.quant[t] {
+			wide = wide || v > 255
+		}
+		if !wide {
+			w.writeBytes(0xFF, 0xDB, 0, 67, byte(t))
+			for k := 0; k < 64; k++ {
+				w.writeByte(byte(ci.quant[t][zigzag[k]]))
+			}
+			continue
+		}
+		sof = 0xC1 // 16-bit tables are not allowed in baseline
+		w.writeBytes(0xFF, 0xDB, 0, 131, 0x10|byte(t))
+		for k := 0; k < 64; k++ {
+			v := ci.quant[t][zigzag[k]]
+			w.writeBytes(byte(v>>8), byte(v))
+		}
+	}
+
+	sofLen := 8 + 3*ci.ncomp
+	w.writeBytes(0xFF, sof, byte(sofLen>>8), byte(sofLen), 8,
+		byte(ci.height>>8), byte(ci.height), byte(ci.width>>8), byte(ci.width), byte(ci.ncomp))
+	for i := 0; i < ci.ncomp; i++ {
+		p := &ci.comp[i]
+		w.writeBytes(byte(i+1), byte(p.h<<4|p.v), p.tq)
+	}
+	tables := 2
+	if ci.ncomp == 1 {
+		tables = 1
+	}
+	writeDHT(w, tables)
+	writeSOS(w, ci.ncomp)
+
+	var prev [3]int32
+	for my := 0; my < ci.mcusY && w.err == nil; my++ {
+		for mx := 0; mx < ci.mcusX; mx++ {
+			for i := 0; i < ci.ncomp; i++ {
+				p := &ci.comp[i]
+				dc, ac := encLumDC, encLumAC
+				if i > 0 {
+					dc, ac = encChromDC, encChromAC
+				}
+				for by := 0; by < p.v; by++ {
+					for bx := 0; bx < p.h; bx++ {
+						prev[i] = w.writeBlock(p.block(mx*p.h+bx, my*p.v+by), prev[i], dc, ac)
+					}
+				}
+			}
+		}
+	}
+	w.pad()
+	w.writeBytes(0xFF, 0xD9)
+}
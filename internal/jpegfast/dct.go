@@ -0,0 +1,200 @@
+package jpegfast
+
+import "math"
+
+// Forward DCTs operate in place on level-shifted samples (-128..127) in
+// natural order. Both leave the result scaled up by 8; the fast variant is
+// additionally scaled by aanScale, which is folded into its quantizer divisors.
+
+// Fixed-point constants of the Loeffler-Ligtenberg-Moschytz DCT (13 bits).
+const (
+	fix_0_298631336 = 2446
+	fix_0_390180644 = 3196
+	fix_0_541196100 = 4433
+	fix_0_765366865 = 6270
+	fix_0_899976223 = 7373
+	fix_1_175875602 = 9633
+	fix_1_501321110 = 12299
+	fix_1_847759065 = 15137
+	fix_1_961570560 = 16069
+	fix_2_053119869 = 16819
+	fix_2_562915447 = 20995
+	fix_3_072711026 = 25172
+
+	constBits = 13
+	pass1Bits = 2
+)
+
+// fdctAccurate is the integer LLM forward DCT (IJG "islow").
+func fdctAccurate(b *[64]int32) {
+	for y := 0; y < 8; y++ {
+		s := b[y*8 : y*8+8 : y*8+8]
+		tmp0 := s[0] + s[7]
+		tmp1 := s[1] + s[6]
+		tmp2 := s[2] + s[5]
+		tmp3 := s[3] + s[4]
+		tmp10 := tmp0 + tmp3
+		tmp12 := tmp0 - tmp3
+		tmp11 := tmp1 + tmp2
+		tmp13 := tmp1 - tmp2
+		tmp0 = s[0] - s[7]
+		tmp1 = s[1] - s[6]
+		tmp2 = s[2] - s[5]
+		tmp3 = s[3] - s[4]
+
+		s[0] = (tmp10 + tmp11) << pass1Bits
+		s[4] = (tmp10 - tmp11) << pass1Bits
+		z1 := (tmp12+tmp13)*fix_0_541196100 + 1<<(constBits-pass1Bits-1)
+		s[2] = (z1 + tmp12*fix_0_765366865) >> (constBits - pass1Bits)
+		s[6] = (z1 - tmp13*fix_1_847759065) >> (constBits - pass1Bits)
+
+		tmp10 = tmp0 + tmp3
+		tmp11 = tmp1 + tmp2
+		tmp12 = tmp0 + tmp2
+		tmp13 = tmp1 + tmp3
+		z1 = (tmp12+tmp13)*fix_1_175875602 + 1<<(constBits-pass1Bits-1)
+		tmp0 *= fix_1_501321110
+		tmp1 *= fix_3_072711026
+		tmp2 *= fix_2_053119869
+		tmp3 *= fix_0_298631336
+		tmp10 *= -fix_0_899976223
+		tmp11 *= -fix_2_562915447
+		tmp12 = tmp12*-fix_0_390180644 + z1
+		tmp13 = tmp13*-fix_1_961570560 + z1
+		s[1] = (tmp0 + tmp10 + tmp12) >> (constBits - pass1Bits)
+		s[3] = (tmp1 + tmp11 + tmp13) >> (constBits - pass1Bits)
+		s[5] = (tmp2 + tmp11 + tmp12) >> (constBits - pass1Bits)
+		s[7] = (tmp3 + tmp10 + tmp13) >> (constBits - pass1Bits)
+	}
+	for x := 0; x < 8; x++ {
+		tmp0 := b[x] + b[56+x]
+		tmp1 := b[8+x] + b[48+x]
+		tmp2 := b[16+x] + b[40+x]
+		tmp3 := b[24+x] + b[32+x]
+		tmp10 := tmp0 + tmp3 + 1<<(pass1Bits-1)
+		tmp12 := tmp0 - tmp3
+		tmp11 := tmp1 + tmp2
+		tmp13 := tmp1 - tmp2
+		tmp0 = b[x] - b[56+x]
+		tmp1 = b[8+x] - b[48+x]
+		tmp2 = b[16+x] - b[40+x]
+		tmp3 = b[24+x] - b[32+x]
+
+		b[x] = (tmp10 + tmp11) >> pass1Bits
+		b[32+x] = (tmp10 - tmp11) >> pass1Bits
+		z1 := (tmp12+tmp13)*fix_0_541196100 + 1<<(constBits+pass1Bits-1)
+		b[16+x] = (z1 + tmp12*fix_0_765366865) >> (constBits + pass1Bits)
+		b[48+x] = (z1 - tmp13*fix_1_847759065) >> (constBits + pass1Bits)
+
+		tmp10 = tmp0 + tmp3
+		tmp11 = tmp1 + tmp2
+		tmp12 = tmp0 + tmp2
+		tmp13 = tmp1 + tmp3
+		z1 = (tmp12+tmp13)*fix_1_175875602 + 1<<(constBits+pass1Bits-1)
+		tmp0 *= fix_1_501321110
+		tmp1 *= fix_3_072711026
+		tmp2 *= fix_2_053119869
+		tmp3 *= fix_0_298631336
+		tmp10 *= -fix_0_899976223
+		tmp11 *= -fix_2_562915447
+		tmp12 = tmp12*-fix_0_390180644 + z1
+		tmp13 = tmp13*-fix_1_961570560 + z1
+		b[8+x] = (tmp0 + tmp10 + tmp12) >> (constBits + pass1Bits)
+		b[24+x] = (tmp1 + tmp11 + tmp13) >> (constBits + pass1Bits)
+		b[40+x] = (tmp2 + tmp11 + tmp12) >> (constBits + pass1Bits)
+		b[56+x] = (tmp3 + tmp10 + tmp13) >> (constBits + pass1Bits)
+	}
+}
+
+// Fixed-point constants of the Arai-Agui-Nakajima DCT (8 bits).
+const (
+	aan_0_382683433 = 98
+	aan_0_541196100 = 139
+	aan_0_707106781 = 181
+	aan_1_306562965 = 334
+)
+
+// fdctFast is the AAN forward DCT (IJG "ifast"): 5 multiplies per 1-D pass
+// at the cost of some precision at high quality settings.
+func fdctFast(b *[64]int32) {
+	for y := 0; y < 8; y++ {
+		aanPass(b[y*8:y*8+8:y*8+8], 1)
+	}
+	for x := 0; x < 8; x++ {
+		aanPass(b[x:], 8)
+	}
+}
+
+func aanPass(s []int32, step int) {
+	d0, d1, d2, d3 := s[0], s[step], s[2*step], s[3*step]
+	d4, d5, d6, d7 := s[4*step], s[5*step], s[6*step], s[7*step]
+	tmp0, tmp7 := d0+d7, d0-d7
+	tmp1, tmp6 := d1+d6, d1-d6
+	tmp2, tmp5 := d2+d5, d2-d5
+	tmp3, tmp4 := d3+d4, d3-d4
+
+	tmp10, tmp13 := tmp0+tmp3, tmp0-tmp3
+	tmp11, tmp12 := tmp1+tmp2, tmp1-tmp2
+	s[0] = tmp10 + tmp11
+	s[4*step] = tmp10 - tmp11
+	z1 := (tmp12 + tmp13) * aan_0_707106781 >> 8
+	s[2*step] = tmp13 + z1
+	s[6*step] = tmp13 - z1
+
+	tmp10 = tmp4 + tmp5
+	tmp11 = tmp5 + tmp6
+	tmp12 = tmp6 + tmp7
+	z5 := (tmp10 - tmp12) * aan_0_382683433 >> 8
+	z2 := tmp10*aan_0_541196100>>8 + z5
+	z4 := tmp12*aan_1_306562965>>8 + z5
+	z3 := tmp11 * aan_0_707106781 >> 8
+	z11, z13 := tmp7+z3, tmp7-z3
+	s[5*step] = z13 + z2
+	s[3*step] = z13 - z2
+	s[step] = z11 + z4
+	s[7*step] = z11 - z4
+}
+
+// aanScale[k] is cos(k*pi/16)*sqrt(2) (1 for k == 0), the per-axis output
+// scale of the AAN transform.
+var aanScale = func() (s [8]float64) {
+	s[0] = 1
+	for k := 1; k < 8; k++ {
+		s[k] = math.Cos(float64(k)*math.Pi/16) * math.Sqrt2
+	}
+	return s
+}()
+
+// quantTable turns forward DCT output into quantized coefficients by
+// multiplying with fixed-point reciprocals of the divisors.
+type quantTable struct {
+	half  [64]int32  // divisor / 2 for rounding
+	recip [64]uint64 // ceil(2^32 / divisor); exact for the divisor range in use
+}
+
+// newQuantTable builds the divisors (natural order) for table q.
+func newQuantTable(q *[64]uint16, fast bool) quantTable {
+	var t quantTable
+	for i, v := range q {
+		var d int32
+		if fast {
+			d = int32(math.Round(float64(v) * 8 * aanScale[i/8] * aanScale[i%8]))
+		} else {
+			d = int32(v) * 8
+		}
+		t.half[i] = d >> 1
+		t.recip[i] = (1<<32 + uint64(d) - 1) / uint64(d)
+	}
+	return t
+}
+
+// quantize divides DCT output by the divisors with round-half-away-from-zero.
+func quantize(b *[64]int32, t *quantTable) {
+	for i, v := range b {
+		if v < 0 {
+			b[i] = -int32((uint64(-v+t.half[i]) * t.recip[i]) >> 32)
+		} else {
+			b[i] = int32((uint64(v+t.half[i]) * t.recip[i]) >> 32)
+		}
+	}
+}
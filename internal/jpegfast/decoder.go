@@ -0,0 +1,557 @@
+package jpegfast
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"sync"
+)
+
+var (
+	ErrFormat      = errors.New("malformed JPEG data")
+	ErrUnsupported = errors.New("unsupported JPEG")
+)
+
+func formatError(msg string) error      { return fmt.Errorf("%w: %s", ErrFormat, msg) }
+func unsupportedError(msg string) error { return fmt.Errorf("%w: %s", ErrUnsupported, msg) }
+
+// DecodeOptions selects how much of an image is reconstructed.
+type DecodeOptions struct {
+	// Scale is the output reduction: 1, 2, 4 or 8 (0 means 1). Scaled
+	// outputs come straight out of a reduced inverse DCT; at 8 only the DC
+	// terms are used.
+	Scale int
+	// Gray skips the chroma IDCT and returns only the luma plane.
+	Gray bool
+	// Flags: FlagFastUpsample replicates chroma in DecodeRGBA.
+	Flags Flags
+}
+
+// Header describes an image as found in its frame header.
+type Header struct {
+	Width, Height int
+	Components    int
+	Progressive   bool
+}
+
+// ScaledSize returns the output size of a decode at the given scale.
+func (hd Header) ScaledSize(scale int) (w, h int) {
+	return (hd.Width + scale - 1) / scale, (hd.Height + scale - 1) / scale
+}
+
+// ScaleFor returns the largest decode scale that still yields an image at
+// least want pixels wide, given the full width.
+func ScaleFor(width, want int) int {
+	for _, s := range [...]int{8, 4, 2} {
+		if (width+s-1)/s >= want {
+			return s
+		}
+	}
+	return 1
+}
+
+// DecodeHeader parses the markers up to the frame header.
+func DecodeHeader(data []byte) (Header, error) {
+	d := decoderPool.Get().(*decoder)
+	defer decoderPool.Put(d)
+	if err := d.parse(data, true); err != nil {
+		return Header{}, err
+	}
+	return Header{Width: d.width, Height: d.height, Components: d.ncomp, Progressive: d.progressive}, nil
+}
+
+// Decode decodes a baseline JPEG into an *image.YCbCr, or an *image.Gray
+// for grayscale input or opts.Gray. Progressive and arithmetic-coded files,
+// CMYK and unusual sampling layouts return ErrUnsupported; callers can fall
+// back to image/jpeg for those.
+func Decode(data []byte, opts DecodeOptions) (image.Image, error) {
+	scale := opts.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	if scale != 1 && scale != 2 && scale != 4 && scale != 8 {
+		return nil, ErrInvalidInput
+	}
+	d := decoderPool.Get().(*decoder)
+	defer decoderPool.Put(d)
+	if err := d.parse(data, false); err != nil {
+		return nil, err
+	}
+	img, err := d.decode(scale, opts.Gray)
+	d.br = bitReader{}
+	return img, err
+}
+
+// DecodeRGBA decodes like Decode and converts the result to RGBA. Chroma is
+// upsampled bilinearly unless opts.Flags has FlagFastUpsample.
+func DecodeRGBA(data []byte, opts DecodeOptions) (*image.RGBA, error) {
+	img, err := Decode(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	switch m := img.(type) {
+	case *image.YCbCr:
+		return ycbcrToRGBA(m, opts.Flags&FlagFastUpsample != 0), nil
+	case *image.Gray:
+		return grayToRGBA(m), nil
+	}
+	return nil, ErrUnsupported
+}
+
+var decoderPool = sync.Pool{New: func() any { return new(decoder) }}
+
+type component struct {
+	id     uint8
+	h, v   int
+	tq     uint8
+	td, ta uint8
+	pred   int32
+}
+
+// decoder holds the tables and frame parameters of one baseline decode.
+type decoder struct {
+	width, height int
+	ncomp         int
+	comp          [3]component
+	hmax, vmax    int
+	progressive   bool
+	restart       int
+	quant         [4][64]int32 // natural order
+	dc, ac        [4]huffDecoder
+	block         [64]int32
+	br            bitReader
+}
+
+// parse reads markers up to the first SOS, or only up to SOF when
+// headerOnly is set, leaving d.br at the start of the entropy-coded data.
+func (d *decoder) parse(data []byte, headerOnly bool) error {
+	d.width, d.height, d.ncomp, d.restart, d.progressive = 0, 0, 0, 0, false
+	for i := range d.dc {
+		d.dc[i].defined, d.ac[i].defined = false, false
+	}
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return formatError("missing SOI marker")
+	}
+	pos := 2
+	for {
+		for pos < len(data) && data[pos] != 0xFF {
+			pos++ // tolerate garbage between segments
+		}
+		for pos < len(data) && data[pos] == 0xFF {
+			pos++
+		}
+		if pos >= len(data) {
+			return formatError("missing SOS marker")
+		}
+		m := data[pos]
+		pos++
+		if m == 0xD8 || m >= 0xD0 && m <= 0xD7 || m == 0x01 {
+			continue
+		}
+		if m == 0xD9 {
+			return formatError("EOI before image data")
+		}
+		if pos+2 > len(data) {
+			return formatError("truncated segment")
+		}
+		n := int(data[pos])<<8 | int(data[pos+1])
+		if n < 2 || pos+n > len(data) {
+			return formatError("truncated segment")
+		}
+		seg := data[pos+2 : pos+n]
+		pos += n
+
+		var err error
+		switch m {
+		case 0xC0, 0xC1:
+			err = d.parseSOF(seg)
+		case 0xC2:
+			d.progressive = true
+			err = d.parseSOF(seg)
+		case 0xC3, 0xC5, 0xC6, 0xC7, 0xC9, 0xCA, 0xCB, 0xCD, 0xCE, 0xCF:
+			return unsupportedError("lossless, hierarchical or arithmetic coding")
+		case 0xC4:
+			err = d.parseDHT(seg)
+		case 0xDB:
+			err = d.parseDQT(seg)
+		case 0xDD:
+			if len(seg) < 2 {
+				return formatError("bad DRI segment")
+			}
+			d.restart = int(seg[0])<<8 | int(seg[1])
+		case 0xDA:
+			if d.ncomp == 0 {
+				return formatError("SOS before SOF")
+			}
+			if d.progressive {
+				return unsupportedError("progressive")
+			}
+			if err := d.parseSOS(seg); err != nil {
+				return err
+			}
+			d.br = bitReader{data: data, pos: pos}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if headerOnly && d.ncomp > 0 {
+			return nil
+		}
+	}
+}
+
+func (d *decoder) parseSOF(seg []byte) error {
+	if len(seg) < 6 {
+		return formatError("bad SOF segment")
+	}
+	if seg[0] != 8 {
+		return unsupportedError("sample precision other than 8 bits")
+	}
+	d.height = int(seg[1])<<8 | int(seg[2])
+	d.width = int(seg[3])<<8 | int(seg[4])
+	n := int(seg[5])
+	if d.width == 0 || d.height == 0 {
+		return unsupportedError("missing image height")
+	}
+	if n != 1 && n != 3 {
+		return unsupportedError(fmt.Sprintf("%d components", n))
+	}
+	if len(seg) < 6+3*n {
+		return formatError("bad SOF segment")
+	}
+	d.ncomp = n
+	d.hmax, d.vmax = 1, 1
+	for i := 0; i < n; i++ {
+		c := &d.comp[i]
+		p := seg[6+3*i:]
+		c.id, c.h, c.v, c.tq = p[0], int(p[1]>>4), int(p[1]&15), p[2]
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 || c.tq > 3 {
+			return formatError("bad component parameters")
+		}
+		if n == 1 {
+			// a single-component scan is never interleaved: one block per MCU
+			c.h, c.v = 1, 1
+		}
+		d.hmax, d.vmax = max(d.hmax, c.h), max(d.vmax, c.v)
+	}
+	if n == 3 {
+		if d.comp[1].h != 1 || d.comp[1].v != 1 || d.comp[2].h != 1 || d.comp[2].v != 1 {
+			return unsupportedError("chroma sampling factors other than 1x1")
+		}
+		if _, ok := subsampleRatio(d.comp[0].h, d.comp[0].v); !ok {
+			return unsupportedError("luma sampling factors")
+		}
+	}
+	return nil
+}
+
+func subsampleRatio(h, v int) (image.YCbCrSubsampleRatio, bool) {
+	switch [2]int{h, v} {
+	case [2]int{1, 1}:
+		return image.YCbCrSubsampleRatio444, true
+	case [2]int{2, 1}:
+		return image.YCbCrSubsampleRatio422, true
+	case [2]int{2, 2}:
+		return image.YCbCrSubsampleRatio420, true
+	case [2]int{1, 2}:
+		return image.YCbCrSubsampleRatio440, true
+	case [2]int{4, 1}:
+		return image.YCbCrSubsampleRatio411, true
+	case [2]int{4, 2}:
+		return image.YCbCrSubsampleRatio410, true
+	}
+	return 0, false
+}
+
+func (d *decoder) parseDQT(seg []byte) error {
+	for len(seg) > 0 {
+		pq, tq := seg[0]>>4, seg[0]&15
+		if tq > 3 || pq > 1 {
+			return formatError("bad DQT segment")
+		}
+		size := 64 << pq
+		if len(seg) < 1+size {
+			return formatError("bad DQT segment")
+		}
+		for k := 0; k < 64; k++ {
+			v := int32(seg[1+k])
+			if pq == 1 {
+				v = int32(seg[1+2*k])<<8 | int32(seg[2+2*k])
+			}
+			d.quant[tq][zigzag[k]] = v
+		}
+		seg = seg[1+size:]
+	}
+	return nil
+}
+
+func (d *decoder) parseDHT(seg []byte) error {
+	for len(seg) > 0 {
+		if len(seg) < 17 {
+			return formatError("bad DHT segment")
+		}
+		tc, th := seg[0]>>4, seg[0]&15
+		if tc > 1 || th > 3 {
+			return formatError("bad DHT segment")
+		}
+		var counts [16]uint8
+		copy(counts[:], seg[1:17])
+		total := 0
+		for _, c := range counts {
+			total += int(c)
+		}
+		if total > 256 || len(seg) < 17+total {
+			return formatError("bad DHT segment")
+		}
+		h := &d.dc[th]
+		if tc == 1 {
+			h = &d.ac[th]
+		}
+		if err := h.build(&counts, seg[17:17+total]); err != nil {
+			return err
+		}
+		seg = seg[17+total:]
+	}
+	return nil
+}
+
+func (d *decoder) parseSOS(seg []byte) error {
+	if len(seg) < 1 {
+		return formatError("bad SOS segment")
+	}
+	ns := int(seg[0])
+	if len(seg) < 4+2*ns {
+		return formatError("bad SOS segment")
+	}
+	if ns != d.ncomp {
+		return unsupportedError("non-interleaved scans")
+	}
+	for i := 0; i < ns; i++ {
+		id, t := seg[1+2*i], seg[2+2*i]
+		c := &d.comp[i]
+		if c.id != id {
+			return unsupportedError("scan component order")
+		}
+		c.td, c.ta = t>>4, t&15
+		if c.td > 3 || c.ta > 3 || !d.dc[c.td].defined || !d.ac[c.ta].defined {
+			return formatError("undefined Huffman table")
+		}
+	}
+	return nil
+}
+
+// mcuCount returns the MCU grid of the frame.
+func (d *decoder) mcuCount() (mcusX, mcusY int) {
+	return (d.width + 8*d.hmax - 1) / (8 * d.hmax), (d.height + 8*d.vmax - 1) / (8 * d.vmax)
+}
+
+// unitQuant leaves coefficients quantized when passed to readBlock.
+var unitQuant = func() (q [64]int32) {
+	for i := range q {
+		q[i] = 1
+	}
+	return q
+}()
+
+// scan runs the entropy decoder over the whole scan and hands every block to
+// fn with its component index and block coordinates. Coefficients are
+// dequantized unless raw is set; ac reports whether any AC term is non-zero.
+// The block is only valid during the call.
+func (d *decoder) scan(raw bool, fn func(ci, bx, by int, b *[64]int32, ac bool)) error {
+	mcusX, mcusY := d.mcuCount()
+	for i := 0; i < d.ncomp; i++ {
+		d.comp[i].pred = 0
+	}
+	br := &d.br
+	mcu := 0
+	for my := 0; my < mcusY; my++ {
+		for mx := 0; mx < mcusX; mx++ {
+			if d.restart > 0 && mcu > 0 && mcu%d.restart == 0 {
+				if br.overrun() {
+					return formatError("truncated restart interval")
+				}
+				if m := br.reset(); m < 0xD0 || m > 0xD7 {
+					return formatError("missing RST marker")
+				}
+				for i := 0; i < d.ncomp; i++ {
+					d.comp[i].pred = 0
+				}
+			}
+			mcu++
+			for i := 0; i < d.ncomp; i++ {
+				c := &d.comp[i]
+				q := &d.quant[c.tq]
+				if raw {
+					q = &unitQuant
+				}
+				for by := 0; by < c.v; by++ {
+					for bx := 0; bx < c.h; bx++ {
+						pred, ac, err := br.readBlock(&d.block, q, c.pred, &d.dc[c.td], &d.ac[c.ta])
+						if err != nil {
+							return err
+						}
+						c.pred = pred
+						fn(i, mx*c.h+bx, my*c.v+by, &d.block, ac)
+					}
+				}
+			}
+		}
+	}
+	if br.overrun() {
+		return formatError("truncated scan")
+	}
+	return nil
+}
+
+// decode reconstructs every block straight into the output planes at
+// 8/scale pixels per block side.
+func (d *decoder) decode(scale int, gray bool) (image.Image, error) {
+	bs := 8 / scale
+	mcusX, mcusY := d.mcuCount()
+	outW, outH := (d.width+scale-1)/scale, (d.height+scale-1)/scale
+	gray = gray || d.ncomp == 1
+
+	// planes are padded to whole MCUs so blocks never need clipping
+	var planes [3][]byte
+	var strides [3]int
+	for i := 0; i < d.ncomp; i++ {
+		if i > 0 && gray {
+			continue
+		}
+		c := &d.comp[i]
+		strides[i] = mcusX * c.h * bs
+		planes[i] = make([]byte, strides[i]*mcusY*c.v*bs)
+	}
+
+	err := d.scan(false, func(i, bx, by int, b *[64]int32, ac bool) {
+		if planes[i] == nil {
+			return
+		}
+		dst := planes[i][by*bs*strides[i]+bx*bs:]
+		switch {
+		case !ac || bs == 1:
+			idctDC(b, dst, strides[i], bs)
+		case bs == 8:
+			idctAccurate(b, dst, strides[i])
+		case bs == 4:
+			idct4(b, dst, strides[i])
+		default:
+			idct2(b, dst, strides[i])
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r := image.Rect(0, 0, outW, outH)
+	if gray {
+		return &image.Gray{Pix: planes[0], Stride: strides[0], Rect: r}, nil
+	}
+	ratio, _ := subsampleRatio(d.comp[0].h, d.comp[0].v)
+	return &image.YCbCr{
+		Y: planes[0], Cb: planes[1], Cr: planes[2],
+		YStride: strides[0], CStride: strides[1],
+		SubsampleRatio: ratio,
+		Rect:           r,
+	}, nil
+}
+
+// ycbcrToRGBA converts with JFIF coefficients. Chroma is interpolated
+// between sample centres, or replicated when fast is set.
+func ycbcrToRGBA(m *image.YCbCr, fast bool) *image.RGBA {
+	b := m.Rect
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	cx, cy := chromaFactors(m.SubsampleRatio)
+	cw, ch := (w+cx-1)/cx, (h+cy-1)/cy
+	xs, ys := upsampleTaps(w, cx, cw, fast), upsampleTaps(h, cy, ch, fast)
+	for y := 0; y < h; y++ {
+		ty := ys[y]
+		r0, r1 := ty.i0*m.CStride, ty.i1*m.CStride
+		yrow := m.Y[y*m.YStride:]
+		out := dst.Pix[y*dst.Stride : y*dst.Stride+4*w]
+		for x := 0; x < w; x++ {
+			tx := xs[x]
+			cb := lerp2(m.Cb, r0, r1, tx, ty.w)
+			cr := lerp2(m.Cr, r0, r1, tx, ty.w)
+			o := 4 * x
+			out[o], out[o+1], out[o+2] = ycbcrToRGB(int32(yrow[x]), cb, cr)
+			out[o+3] = 0xFF
+		}
+	}
+	return dst
+}
+
+// ycbcrToRGB converts one JFIF sample with centred chroma.
+func ycbcrToRGB(y, cb, cr int32) (byte, byte, byte) {
+	yy := y<<16 + 1<<15
+	return clampSample((yy + 91881*cr) >> 16),
+		clampSample((yy - 22554*cb - 46802*cr) >> 16),
+		clampSample((yy + 116130*cb) >> 16)
+}
+
+// tap locates an output pixel between two chroma samples; w is the weight
+// of i1 out of 256.
+type tap struct {
+	i0, i1 int
+	w      int32
+}
+
+func upsampleTaps(n, f, cn int, fast bool) []tap {
+	t := make([]tap, n)
+	for i := range t {
+		if fast || f == 1 {
+			t[i] = tap{i0: i / f, i1: i / f}
+			continue
+		}
+		// sample centres sit at (j+0.5)*f - 0.5 in output coordinates
+		pos := (2*i + 1 - f) * 256 / (2 * f)
+		j := pos >> 8 // floor, also for the negative first position
+		w := int32(pos - j*256)
+		i0, i1 := max(j, 0), min(j+1, cn-1)
+		t[i] = tap{i0: min(i0, cn-1), i1: i1, w: w}
+	}
+	return t
+}
+
+// lerp2 returns the bilinearly weighted chroma sample, centred on zero.
+func lerp2(p []byte, r0, r1 int, tx tap, wy int32) int32 {
+	a := int32(p[r0+tx.i0])*(256-tx.w) + int32(p[r0+tx.i1])*tx.w
+	if r1 != r0 {
+		c := int32(p[r1+tx.i0])*(256-tx.w) + int32(p[r1+tx.i1])*tx.w
+		a = (a*(256-wy) + c*wy + 1<<15) >> 16
+	} else {
+		a = (a + 1<<7) >> 8
+	}
+	return a - 128
+}
+
+func chromaFactors(r image.YCbCrSubsampleRatio) (cx, cy int) {
+	switch r {
+	case image.YCbCrSubsampleRatio422:
+		return 2, 1
+	case image.YCbCrSubsampleRatio420:
+		return 2, 2
+	case image.YCbCrSubsampleRatio440:
+		return 1, 2
+	case image.YCbCrSubsampleRatio411:
+		return 4, 1
+	case image.YCbCrSubsampleRatio410:
+		return 4, 2
+	}
+	return 1, 1
+}
+
+func grayToRGBA(m *image.Gray) *image.RGBA {
+	w, h := m.Rect.Dx(), m.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		src := m.Pix[y*m.Stride : y*m.Stride+w]
+		out := dst.Pix[y*dst.Stride:]
+		for x, v := range src {
+			out[4*x], out[4*x+1], out[4*x+2], out[4*x+3] = v, v, v, 0xFF
+		}
+	}
+	return dst
+}
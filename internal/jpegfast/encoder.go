@@ -1,10 +1,9 @@
 package jpegfast
 
 import (
-	"bytes"
 	"errors"
 	"image"
-	"image/jpeg"
+	"sync"
 )
 
 type Subsampling int
@@ -18,12 +17,38 @@ const (
 	SubsampleGray
 )
 
+// lumaFactors are the luma (H, V) sampling factors of each mode; chroma is
+// always sampled 1x1, so these are also the chroma decimation ratios.
+var lumaFactors = [...][2]int{
+	Subsample444:  {1, 1},
+	Subsample422:  {2, 1},
+	Subsample420:  {2, 2},
+	Subsample440:  {1, 2},
+	Subsample411:  {4, 1},
+	SubsampleGray: {1, 1},
+}
+
+func (s Subsampling) valid() bool { return s >= Subsample444 && s <= SubsampleGray }
+
+// MCUSize returns the pixel size of one minimum coded unit.
+func (s Subsampling) MCUSize() (w, h int) {
+	f := lumaFactors[s]
+	return 8 * f[0], 8 * f[1]
+}
+
 type Flags uint32
 
 const (
+	// FlagAccurateDCT selects the integer LLM DCT. It is the default and wins
+	// over FlagFastDCT when both are set.
 	FlagAccurateDCT Flags = 1 << iota
+	// FlagFastDCT selects the AAN DCT, faster but less precise at high quality.
 	FlagFastDCT
+	// FlagFastUpsample only affects decoding: DecodeRGBA replicates chroma
+	// instead of interpolating it.
 	FlagFastUpsample
+	// FlagNoRealloc makes the encoder write into a single buffer of BufSize
+	// bytes (or the caller's capacity) and fail rather than grow it.
 	FlagNoRealloc
 )
 
@@ -31,13 +56,167 @@ type EncodeConfig struct {
 	Quality     int
 	Subsampling Subsampling
 	Flags       Flags
+	// Workers > 1 splits the image into strips of MCU rows that are encoded
+	// concurrently and joined with restart markers. 0 or 1 encodes serially.
+	Workers int
 }
 
-var ErrInvalidInput = errors.New("invalid dimensions or buffer length")
+var (
+	ErrInvalidInput       = errors.New("invalid dimensions or buffer length")
+	ErrInvalidSubsampling = errors.New("invalid subsampling mode")
+	ErrBufferTooSmall     = errors.New("output buffer too small")
+)
 
+// BufSize returns the worst-case size of an encoded image, including headers.
+func BufSize(width, height int, sub Subsampling) int {
+	if !sub.valid() {
+		sub = Subsample444
+	}
+	mw, mh := sub.MCUSize()
+	pw := (width + mw - 1) / mw * mw
+	ph := (height + mh - 1) / mh * mh
+	chroma := 0
+	if sub != SubsampleGray {
+		chroma = 4 * 64 / (mw * mh)
+	}
+	return pw*ph*(2+chroma) + 2048
+}
+
+// EncodeBGR encodes packed 24-bit BGR pixels as a baseline JPEG.
 func EncodeBGR(bgr []byte, width, height int, cfg EncodeConfig) ([]byte, error) {
+	enc, err := NewEncoder(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var dst []byte
+	if cfg.Flags&FlagNoRealloc != 0 {
+		dst = make([]byte, 0, BufSize(width, height, cfg.Subsampling))
+	}
+	return enc.AppendBGR(dst, bgr, width, height)
+}
+
+// Encoder encodes images with a fixed configuration, reusing pooled scratch
+// memory between calls. It is safe for concurrent use.
+type Encoder struct {
+	base encoder // quantization setup; sizes are filled in per call
+	pool sync.Pool
+}
+
+// scratch is the per-call working set kept in the Encoder pool.
+type scratch struct {
+	enc    encoder
+	mcu    mcuScratch
+	w      bitWriter
+	strips [][]byte // per-strip output for parallel encodes
+	bgr    bgrSampler
+	rgba   rgbaSampler
+	ycc    ycbcrSampler
+	gray   graySampler
+	img    imageSampler
+}
+
+func NewEncoder(cfg EncodeConfig) (*Encoder, error) {
+	base, err := newEncoder(cfg)
+	if err != nil {
+		return nil, err
+	}
+	e := &Encoder{base: *base}
+	e.pool.New = func() any { return new(scratch) }
+	return e, nil
+}
+
+// Config returns the normalized configuration of the encoder.
+func (e *Encoder) Config() EncodeConfig { return e.base.cfg }
+
+// AppendBGR appends the JPEG encoding of packed 24-bit BGR pixels to dst.
+func (e *Encoder) AppendBGR(dst, bgr []byte, width, height int) ([]byte, error) {
 	if width <= 0 || height <= 0 || len(bgr) != width*height*3 {
-		return nil, ErrInvalidInput
+		return dst, ErrInvalidInput
+	}
+	sc := e.pool.Get().(*scratch)
+	defer e.pool.Put(sc)
+	sc.bgr = bgrSampler{pix: bgr, stride: width * 3, w: width, h: height}
+	out, err := e.appendEncode(dst, width, height, &sc.bgr, sc)
+	sc.bgr = bgrSampler{}
+	return out, err
+}
+
+// AppendEncode appends the JPEG encoding of img to dst. *image.RGBA,
+// *image.NRGBA, *image.YCbCr and *image.Gray are read directly without
+// conversion; other image types go through the generic color model.
+// With FlagNoRealloc, dst is never grown: ErrBufferTooSmall is returned
+// when its capacity is insufficient (BufSize is always enough).
+func (e *Encoder) AppendEncode(dst []byte, img image.Image) ([]byte, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= 0 || h <= 0 {
+		return dst, ErrInvalidInput
+	}
+	sc := e.pool.Get().(*scratch)
+	defer e.pool.Put(sc)
+	var src sampler
+	switch m := img.(type) {
+	case *image.RGBA:
+		sc.rgba = rgbaSampler{pix: m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], stride: m.Stride, w: w, h: h}
+		src = &sc.rgba
+	case *image.NRGBA:
+		sc.rgba = rgbaSampler{pix: m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], stride: m.Stride, w: w, h: h}
+		src = &sc.rgba
+	case *image.YCbCr:
+		sc.ycc.reset(m)
+		src = &sc.ycc
+	case *image.Gray:
+		sc.gray = graySampler{pix: m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], stride: m.Stride, w: w, h: h}
+		src = &sc.gray
+	default:
+		sc.img = imageSampler{img: img, w: w, h: h}
+		src = &sc.img
+	}
+	out, err := e.appendEncode(dst, w, h, src, sc)
+	// drop image references so pooled scratch does not pin frames
+	sc.rgba, sc.ycc, sc.gray, sc.img = rgbaSampler{}, ycbcrSampler{}, graySampler{}, imageSampler{}
+	return out, err
+}
+
+func (e *Encoder) appendEncode(dst []byte, width, height int, src sampler, sc *scratch) ([]byte, error) {
+	// the per-call copy lives in the scratch: strip workers share it, which
+	// would otherwise move it to the heap on every call
+	sc.enc = e.base
+	enc := &sc.enc
+	if err := enc.setSize(width, height); err != nil {
+		return dst, err
+	}
+	sc.w = bitWriter{buf: dst, fixed: enc.cfg.Flags&FlagNoRealloc != 0}
+	if rows, strips := stripPlan(enc.mcusX, enc.mcusY, enc.cfg.Workers); strips > 1 {
+		e.encodeParallel(enc, &sc.w, src, sc, rows, strips)
+	} else {
+		enc.encode(&sc.w, src, &sc.mcu)
+	}
+	out, err := sc.w.buf, sc.w.err
+	sc.w = bitWriter{}
+	if err != nil {
+		return dst, err
+	}
+	return out, nil
+}
+
+// encoder holds the per-image parameters of a baseline encode.
+type encoder struct {
+	width, height int
+	cfg           EncodeConfig
+	ncomp         int
+	hs, vs        int // luma sampling factors
+	mcuW, mcuH    int
+	mcusX, mcusY  int
+	quant         [2][64]uint16 // natural order
+	div           [2]quantTable
+	fast          bool
+	restart       int // MCUs per restart interval, 0 for none
+}
+
+func newEncoder(cfg EncodeConfig) (*encoder, error) {
+	if !cfg.Subsampling.valid() {
+		return nil, ErrInvalidSubsampling
 	}
 	if cfg.Quality < 1 {
 		cfg.Quality = 1
@@ -45,34 +224,187 @@ func EncodeBGR(bgr []byte, width, height int, cfg EncodeConfig) ([]byte, error)
 	if cfg.Quality > 100 {
 		cfg.Quality = 100
 	}
-	img := bgrToNRGBA(bgr, width, height)
-	return encodeNRGBA(img, cfg.Quality)
-}
-
-func bgrToNRGBA(bgr []byte, width, height int) *image.NRGBA {
-	img := image.NewNRGBA(image.Rect(0, 0, width, height))
-	dst := img.Pix
-	stride := img.Stride
-	for y := 0; y < height; y++ {
-		rowDst := dst[y*stride : y*stride+width*4]
-		rowSrc := bgr[y*width*3 : y*width*3+width*3]
-		for x := 0; x < width; x++ {
-			b := rowSrc[x*3+0]
-			g := rowSrc[x*3+1]
-			r := rowSrc[x*3+2]
-			rowDst[x*4+0] = r
-			rowDst[x*4+1] = g
-			rowDst[x*4+2] = b
-			rowDst[x*4+3] = 0xFF
+	e := &encoder{cfg: cfg, ncomp: 3}
+	if cfg.Subsampling == SubsampleGray {
+		e.ncomp = 1
+	}
+	e.hs, e.vs = lumaFactors[cfg.Subsampling][0], lumaFactors[cfg.Subsampling][1]
+	e.mcuW, e.mcuH = 8*e.hs, 8*e.vs
+	e.fast = cfg.Flags&FlagFastDCT != 0 && cfg.Flags&FlagAccurateDCT == 0
+	for t := 0; t < 2; t++ {
+		e.quant[t] = scaleQuant(&baseQuant[t], cfg.Quality)
+		e.div[t] = newQuantTable(&e.quant[t], e.fast)
+	}
+	return e, nil
+}
+
+func (e *encoder) setSize(width, height int) error {
+	if width <= 0 || height <= 0 || width > 65535 || height > 65535 {
+		return ErrInvalidInput
+	}
+	e.width, e.height = width, height
+	e.mcusX = (width + e.mcuW - 1) / e.mcuW
+	e.mcusY = (height + e.mcuH - 1) / e.mcuH
+	return nil
+}
+
+// mcuScratch is the working memory for coding one MCU.
+type mcuScratch struct {
+	y, cb, cr [maxMCUPixels]int32
+	blk       [64]int32
+}
+
+func (e *encoder) encode(w *bitWriter, src sampler, sc *mcuScratch) {
+	e.writeHeaders(w)
+	e.encodeRows(w, src, 0, e.mcusY, sc)
+	w.pad()
+	w.writeBytes(0xFF, 0xD9)
+}
+
+// encodeRows codes MCU rows [row0, row1) with fresh DC predictors.
+func (e *encoder) encodeRows(w *bitWriter, src sampler, row0, row1 int, sc *mcuScratch) {
+	var prev [3]int32
+	n := e.mcuW * e.mcuH
+	yy := sc.y[:n]
+	var cb, cr []int32
+	if e.ncomp == 3 {
+		cb, cr = sc.cb[:n], sc.cr[:n]
+	}
+	for my := row0; my < row1 && w.err == nil; my++ {
+		for mx := 0; mx < e.mcusX; mx++ {
+			src.load(mx*e.mcuW, my*e.mcuH, e.mcuW, e.mcuH, yy, cb, cr)
+			prev = e.encodeMCU(w, sc, prev)
 		}
 	}
-	return img
 }
 
-func encodeNRGBA(img image.Image, quality int) ([]byte, error) {
-	var buf bytes.Buffer
-	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
-		return nil, err
+// encodeMCU codes the samples already loaded into sc.
+func (e *encoder) encodeMCU(w *bitWriter, sc *mcuScratch, prev [3]int32) [3]int32 {
+	for by := 0; by < e.vs; by++ {
+		for bx := 0; bx < e.hs; bx++ {
+			base := by*8*e.mcuW + bx*8
+			for r := 0; r < 8; r++ {
+				copy(sc.blk[r*8:r*8+8], sc.y[base+r*e.mcuW:base+r*e.mcuW+8])
+			}
+			prev[0] = e.codeBlock(w, &sc.blk, 0, prev[0], encLumDC, encLumAC)
+		}
+	}
+	if e.ncomp == 1 {
+		return prev
+	}
+	downsample(&sc.blk, sc.cb[:], e.mcuW, e.hs, e.vs)
+	prev[1] = e.codeBlock(w, &sc.blk, 1, prev[1], encChromDC, encChromAC)
+	downsample(&sc.blk, sc.cr[:], e.mcuW, e.hs, e.vs)
+	prev[2] = e.codeBlock(w, &sc.blk, 1, prev[2], encChromDC, encChromAC)
+	return prev
+}
+
+func (e *encoder) codeBlock(w *bitWriter, blk *[64]int32, table int, prev int32, dc, ac *huffEncoder) int32 {
+	if e.fast {
+		fdctFast(blk)
+	} else {
+		fdctAccurate(blk)
+	}
+	quantize(blk, &e.div[table])
+	return w.writeBlock(blk, prev, dc, ac)
+}
+
+// downsample box-averages an MCU plane of the given stride into one 8x8 block.
+func downsample(blk *[64]int32, plane []int32, stride, hs, vs int) {
+	switch {
+	case hs == 1 && vs == 1:
+		for r := 0; r < 8; r++ {
+			copy(blk[r*8:r*8+8], plane[r*stride:r*stride+8])
+		}
+	case hs == 2 && vs == 2:
+		for r := 0; r < 8; r++ {
+			a := plane[2*r*stride : 2*r*stride+16]
+			b := plane[(2*r+1)*stride : (2*r+1)*stride+16]
+			for c := 0; c < 8; c++ {
+				// +2 rounds; the alternating bias of libjpeg is not worth the branch
+				blk[r*8+c] = (a[2*c] + a[2*c+1] + b[2*c] + b[2*c+1] + 2) >> 2
+			}
+		}
+	default:
+		n := int32(hs * vs)
+		for r := 0; r < 8; r++ {
+			for c := 0; c < 8; c++ {
+				var sum int32
+				for dy := 0; dy < vs; dy++ {
+					row := plane[(r*vs+dy)*stride+c*hs:]
+					for dx := 0; dx < hs; dx++ {
+						sum += row[dx]
+					}
+				}
+				// floor((sum + n/2) / n), valid for negative sums too
+				blk[r*8+c] = (sum+n/2+128*n)/n - 128
+			}
+		}
+	}
+}
+
+// jfifHeader is SOI plus a JFIF APP0 segment (version 1.01, no density, no
+// thumbnail).
+var jfifHeader = []byte{0xFF, 0xD8,
+	0xFF, 0xE0, 0x00, 0x10, 'J', 'F', 'I', 'F', 0x00, 0x01, 0x01, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00}
+
+func (e *encoder) writeHeaders(w *bitWriter) {
+	w.writeBytes(jfifHeader...)
+
+	tables := 2
+	if e.ncomp == 1 {
+		tables = 1
+	}
+	dqtLen := 2 + tables*65
+	w.writeBytes(0xFF, 0xDB, byte(dqtLen>>8), byte(dqtLen))
+	for t := 0; t < tables; t++ {
+		w.writeByte(byte(t))
+		for k := 0; k < 64; k++ {
+			w.writeByte(byte(e.quant[t][zigzag[k]]))
+		}
+	}
+
+	sofLen := 8 + 3*e.ncomp
+	w.writeBytes(0xFF, 0xC0, byte(sofLen>>8), byte(sofLen), 8,
+		byte(e.height>>8), byte(e.height), byte(e.width>>8), byte(e.width), byte(e.ncomp))
+	w.writeBytes(1, byte(e.hs<<4|e.vs), 0)
+	if e.ncomp == 3 {
+		w.writeBytes(2, 0x11, 1, 3, 0x11, 1)
+	}
+
+	writeDHT(w, tables)
+
+	if e.restart > 0 {
+		w.writeBytes(0xFF, 0xDD, 0x00, 0x04, byte(e.restart>>8), byte(e.restart))
+	}
+
+	writeSOS(w, e.ncomp)
+}
+
+// writeDHT emits the standard luma tables, plus the chroma ones when tables
+// is 2, as table ids 0 and 1.
+func writeDHT(w *bitWriter, tables int) {
+	specs := []*huffSpec{&huffLumDC, &huffLumAC, &huffChromDC, &huffChromAC}
+	classes := []byte{0x00, 0x10, 0x01, 0x11}
+	dhtLen := 2
+	for i := 0; i < 2*tables; i++ {
+		dhtLen += 17 + len(specs[i].values)
+	}
+	w.writeBytes(0xFF, 0xC4, byte(dhtLen>>8), byte(dhtLen))
+	for i := 0; i < 2*tables; i++ {
+		w.writeByte(classes[i])
+		w.writeBytes(specs[i].counts[:]...)
+		w.writeBytes(specs[i].values...)
+	}
+}
+
+// writeSOS starts a sequential scan of components 1..ncomp, luma on
+// Huffman tables 0 and chroma on tables 1.
+func writeSOS(w *bitWriter, ncomp int) {
+	sosLen := 6 + 2*ncomp
+	w.writeBytes(0xFF, 0xDA, byte(sosLen>>8), byte(sosLen), byte(ncomp), 1, 0x00)
+	if ncomp == 3 {
+		w.writeBytes(2, 0x11, 3, 0x11)
 	}
-	return buf.Bytes(), nil
+	w.writeBytes(0, 63, 0)
 }
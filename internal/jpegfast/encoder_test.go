@@ -0,0 +1,202 @@
+package jpegfast
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// psnr returns the peak signal-to-noise ratio of b against a over their
+// RGB channels, in dB.
+func psnr(a, b image.Image) float64 {
+	r := a.Bounds()
+	var sum float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c1 := color.RGBAModel.Convert(a.At(x, y)).(color.RGBA)
+			c2 := color.RGBAModel.Convert(b.At(x, y)).(color.RGBA)
+			for _, d := range [3]float64{float64(c1.R) - float64(c2.R), float64(c1.G) - float64(c2.G), float64(c1.B) - float64(c2.B)} {
+				sum += d * d
+			}
+		}
+	}
+	mse := sum / float64(3*r.Dx()*r.Dy())
+	if mse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/mse)
+}
+
+func toGray(img image.Image) *image.Gray {
+	g := image.NewGray(img.Bounds())
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			g.Set(x, y, img.At(x, y))
+		}
+	}
+	return g
+}
+
+// TestEncodePSNR decodes the encoder's output with image/jpeg and holds its
+// PSNR to that of image/jpeg's own encoder at the same quality. image/jpeg
+// only writes 4:2:0 (or gray), so modes keeping more chroma must do at
+// least as well and 4:1:1, which keeps less, may fall a little short. The
+// smooth fixture keeps the comparison about coding, not noise.
+func TestEncodePSNR(t *testing.T) {
+	src := testImage(203, 117) // not a multiple of any MCU size
+	gray := toGray(src)
+	modes := []struct {
+		name  string
+		sub   Subsampling
+		slack float64 // dB below image/jpeg allowed
+	}{
+		{"444", Subsample444, 0},
+		{"422", Subsample422, 0},
+		{"420", Subsample420, 0.3},
+		{"440", Subsample440, 0.3},
+		{"411", Subsample411, 2},
+		{"gray", SubsampleGray, 0.3},
+	}
+	for _, q := range []int{50, 75, 90} {
+		for _, m := range modes {
+			in := image.Image(src)
+			if m.sub == SubsampleGray {
+				in = gray
+			}
+			var std bytes.Buffer
+			if err := jpeg.Encode(&std, in, &jpeg.Options{Quality: q}); err != nil {
+				t.Fatal(err)
+			}
+			stdImg, err := jpeg.Decode(&std)
+			if err != nil {
+				t.Fatal(err)
+			}
+			floor := psnr(in, stdImg) - m.slack
+			for _, flags := range []Flags{0, FlagAccurateDCT, FlagFastDCT, FlagNoRealloc} {
+				t.Run(fmt.Sprintf("q%d/%s/flags%d", q, m.name, flags), func(t *testing.T) {
+					jpg := encodeTest(t, in, EncodeConfig{Quality: q, Subsampling: m.sub, Flags: flags})
+					got, err := jpeg.Decode(bytes.NewReader(jpg))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got.Bounds() != src.Bounds() {
+						t.Fatalf("bounds %v, want %v", got.Bounds(), src.Bounds())
+					}
+					if _, isGray := got.(*image.Gray); isGray != (m.sub == SubsampleGray) {
+						t.Errorf("decoded as %T", got)
+					}
+					limit := floor
+					if flags == FlagFastDCT {
+						limit -= 0.5 // documented as less precise at high quality
+					}
+					if p := psnr(in, got); p < limit {
+						t.Errorf("PSNR %.2f dB, want at least %.2f dB", p, limit)
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestEncodeInputs checks that every input path encodes the same picture.
+func TestEncodeInputs(t *testing.T) {
+	src := testImage(64, 40)
+	bgr := make([]byte, 0, 64*40*3)
+	nrgba := image.NewNRGBA(src.Bounds())
+	ycc := image.NewYCbCr(src.Bounds(), image.YCbCrSubsampleRatio444)
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 64; x++ {
+			c := src.RGBAAt(x, y)
+			bgr = append(bgr, c.B, c.G, c.R)
+			nrgba.SetNRGBA(x, y, color.NRGBA{c.R, c.G, c.B, 255})
+			yy, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+			ycc.Y[ycc.YOffset(x, y)], ycc.Cb[ycc.COffset(x, y)], ycc.Cr[ycc.COffset(x, y)] = yy, cb, cr
+		}
+	}
+	cfg := EncodeConfig{Quality: 85, Subsampling: Subsample420}
+	fromBGR, err := EncodeBGR(bgr, 64, 40, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, img := range map[string]image.Image{"RGBA": src, "NRGBA": nrgba, "YCbCr": ycc} {
+		jpg := encodeTest(t, img, cfg)
+		a, _ := jpeg.Decode(bytes.NewReader(jpg))
+		b, _ := jpeg.Decode(bytes.NewReader(fromBGR))
+		if p := psnr(a, b); p < 40 {
+			t.Errorf("%s differs from BGR input: PSNR %.2f dB", name, p)
+		}
+	}
+}
+
+func TestNoRealloc(t *testing.T) {
+	src := testImage(96, 64)
+	enc, err := NewEncoder(EncodeConfig{Quality: 80, Subsampling: Subsample420, Flags: FlagNoRealloc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 0, BufSize(96, 64, Subsample420))
+	out, err := enc.AppendEncode(buf, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &out[:1][0] != &buf[:1][0] {
+		t.Error("output does not share the caller's buffer")
+	}
+
+	small := make([]byte, 3, 100)
+	got, err := enc.AppendEncode(small, src)
+	if err != ErrBufferTooSmall {
+		t.Fatalf("got %v, want ErrBufferTooSmall", err)
+	}
+	if len(got) != 3 || cap(got) != 100 {
+		t.Errorf("dst changed on failure: len %d cap %d", len(got), cap(got))
+	}
+
+	// the same call without the flag grows the buffer
+	enc, _ = NewEncoder(EncodeConfig{Quality: 80, Subsampling: Subsample420})
+	if got, err = enc.AppendEncode(small, src); err != nil || !bytes.Equal(got[3:], out) {
+		t.Errorf("growing encode: err %v, output differs", err)
+	}
+}
+
+func TestEncodeZeroAllocs(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+	src := testImage(320, 240)
+	enc, _ := NewEncoder(EncodeConfig{Quality: 80, Subsampling: Subsample420})
+	dst := make([]byte, 0, BufSize(320, 240, Subsample420))
+	enc.AppendEncode(dst, src) // warm the pool
+	if n := testing.AllocsPerRun(20, func() { enc.AppendEncode(dst[:0], src) }); n != 0 {
+		t.Errorf("%v allocs per frame, want 0", n)
+	}
+}
+
+func BenchmarkAppendEncode(b *testing.B) {
+	src := testImage(1920, 1080)
+	for _, sub := range []struct {
+		name string
+		sub  Subsampling
+	}{{"420", Subsample420}, {"444", Subsample444}} {
+		b.Run(sub.name, func(b *testing.B) {
+			enc, _ := NewEncoder(EncodeConfig{Quality: 80, Subsampling: sub.sub})
+			dst := make([]byte, 0, BufSize(1920, 1080, sub.sub))
+			b.ReportAllocs()
+			b.SetBytes(int64(len(src.Pix)))
+			b.ResetTimer()
+			for b.Loop() {
+				var err error
+				if dst, err = enc.AppendEncode(dst[:0], src); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if n := testing.AllocsPerRun(5, func() { enc.AppendEncode(dst[:0], src) }); n != 0 {
+				b.Errorf("%v allocs per frame in steady state, want 0", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package jpegfast
+
+import "math/bits"
+
+// bitWriter appends entropy-coded data to buf, inserting the 0x00 stuffing
+// byte after every 0xFF. With fixed set it never grows buf beyond its
+// capacity and records ErrBufferTooSmall instead.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+	fixed bool
+	err   error
+}
+
+func (w *bitWriter) writeByte(b byte) {
+	if w.fixed && len(w.buf) == cap(w.buf) {
+		w.err = ErrBufferTooSmall
+		return
+	}
+	w.buf = append(w.buf, b)
+}
+
+func (w *bitWriter) writeBytes(p ...byte) {
+	if w.fixed && len(w.buf)+len(p) > cap(w.buf) {
+		w.err = ErrBufferTooSmall
+		return
+	}
+	w.buf = append(w.buf, p...)
+}
+
+// emit writes the low n bits of v, most significant first.
+func (w *bitWriter) emit(v uint32, n uint) {
+	w.acc = w.acc<<n | uint64(v)&(1<<n-1)
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.nbits -= 8
+		b := byte(w.acc >> w.nbits)
+		w.writeByte(b)
+		if b == 0xFF {
+			w.writeByte(0)
+		}
+	}
+}
+
+// pad fills the last partial byte with 1 bits, as required before a marker.
+func (w *bitWriter) pad() {
+	if w.nbits > 0 {
+		w.emit(0xFF, 8-w.nbits)
+	}
+}
+
+func (w *bitWriter) emitHuff(h *huffEncoder, sym uint8) {
+	c := h[sym]
+	w.emit(uint32(c)&0xFFFFFF, uint(c>>24))
+}
+
+// emitValue writes symbol (run<<4 | size) followed by the size-bit
+// magnitude of v in JPEG's one's-complement form.
+func (w *bitWriter) emitValue(h *huffEncoder, run uint8, v int32) {
+	a := v
+	if a < 0 {
+		a = -a
+		v--
+	}
+	size := uint(bits.Len32(uint32(a)))
+	w.emitHuff(h, run<<4|uint8(size))
+	if size > 0 {
+		w.emit(uint32(v), size)
+	}
+}
+
+// writeBlock entropy-codes one quantized block (natural order) and returns
+// its DC value as the next predictor.
+func (w *bitWriter) writeBlock(b *[64]int32, prevDC int32, dc, ac *huffEncoder) int32 {
+	d := min(max(b[0], -1024), 1023)
+	w.emitValue(dc, 0, d-prevDC)
+	run := uint8(0)
+	for k := 1; k < 64; k++ {
+		v := b[zigzag[k]]
+		if v == 0 {
+			run++
+			continue
+		}
+		for run > 15 {
+			w.emitHuff(ac, 0xF0)
+			run -= 16
+		}
+		w.emitValue(ac, run, min(max(v, -1023), 1023))
+		run = 0
+	}
+	if run > 0 {
+		w.emitHuff(ac, 0x00)
+	}
+	return d
+}
+
+// lutBits is the code length resolved by a single huffDecoder table lookup;
+// longer codes fall back to the canonical maxCode walk.
+const lutBits = 9
+
+// huffDecoder decodes one DHT table.
+type huffDecoder struct {
+	lut     [1 << lutBits]uint16 // length<<8 | symbol, 0 when the code is longer
+	minCode [17]int32
+	maxCode [17]int32 // -1 when there are no codes of that length
+	valPtr  [17]int32
+	vals    [256]uint8
+	defined bool
+}
+
+// checkHuffCounts reports whether the 16 code-length counts of a DHT table
+// fit the code space: by the Kraft inequality, the codes of n symbols of
+// length l take n·2^(16-l) of the 2^16 16-bit codes.
+func checkHuffCounts(counts *[16]uint8) error {
+	space, total := 0, 0
+	for l, n := range counts {
+		space += int(n) << (15 - l)
+		total += int(n)
+	}
+	if space > 1<<16 || total > 256 {
+		return formatError("bad Huffman table")
+	}
+	return nil
+}
+
+// build fills the decoder from the 16 code-length counts and the symbols.
+// The counts are checked before the lookup table is filled, so a table
+// over-subscribing the code space cannot index past it.
+func (h *huffDecoder) build(counts *[16]uint8, vals []uint8) error {
+	*h = huffDecoder{}
+	if err := checkHuffCounts(counts); err != nil {
+		return err
+	}
+	total := 0
+	for _, n := range counts {
+		total += int(n)
+	}
+	if len(vals) < total {
+		return formatError("bad Huffman table")
+	}
+	copy(h.vals[:], vals)
+	code, k := int32(0), int32(0)
+	for l := 1; l <= 16; l++ {
+		n := int32(counts[l-1])
+		h.minCode[l], h.valPtr[l] = code, k
+		h.maxCode[l] = -1
+		if n > 0 {
+			h.maxCode[l] = code + n - 1
+		}
+		for i := int32(0); i < n; i++ {
+			if l <= lutBits {
+				shift := lutBits - l
+				for j := int32(0); j < 1<<shift; j++ {
+					h.lut[code<<shift|j] = uint16(l)<<8 | uint16(vals[k])
+				}
+			}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	h.defined = true
+	return nil
+}
+
+// bitReader reads entropy-coded data MSB first, removing byte stuffing. It
+// stops at the first marker and feeds zero bytes past it (or past the end of
+// data); pad counts those so over-reads can be detected.
+type bitReader struct {
+	data   []byte
+	pos    int
+	acc    uint64 // pending bits, left aligned
+	n      uint
+	pad    int
+	marker byte // marker that ended the segment, 0 while inside it
+}
+
+func (r *bitReader) fill() {
+	for r.n <= 56 {
+		var b byte
+		if r.marker == 0 && r.pos < len(r.data) {
+			b = r.data[r.pos]
+			r.pos++
+			if b == 0xFF {
+				for r.pos < len(r.data) && r.data[r.pos] == 0xFF {
+					r.pos++
+				}
+				if r.pos == len(r.data) {
+					r.marker, b = 0xD9, 0
+					r.pad++
+				} else if m := r.data[r.pos]; m == 0 {
+					r.pos++
+				} else {
+					r.pos++
+					r.marker, b = m, 0
+					r.pad++
+				}
+			}
+		} else {
+			if r.marker == 0 {
+				r.marker = 0xD9 // ran off the end
+			}
+			r.pad++
+		}
+		r.acc |= uint64(b) << (56 - r.n)
+		r.n += 8
+	}
+}
+
+// overrun reports whether more bits were consumed than the segment holds.
+func (r *bitReader) overrun() bool { return r.pad*8 > int(r.n) }
+
+// reset discards buffered bits and skips to the next marker, which it
+// returns, leaving the reader positioned after it.
+func (r *bitReader) reset() byte {
+	if r.marker == 0 {
+		for r.pos+1 < len(r.data) && (r.data[r.pos] != 0xFF || r.data[r.pos+1] == 0 || r.data[r.pos+1] == 0xFF) {
+			r.pos++
+		}
+		if r.pos+1 < len(r.data) {
+			r.marker = r.data[r.pos+1]
+			r.pos += 2
+		}
+	}
+	m := r.marker
+	r.acc, r.n, r.pad, r.marker = 0, 0, 0, 0
+	return m
+}
+
+func (r *bitReader) decodeHuff(h *huffDecoder) (uint8, error) {
+	if r.n < 16 {
+		r.fill()
+	}
+	if e := h.lut[r.acc>>(64-lutBits)]; e != 0 {
+		l := uint(e >> 8)
+		r.acc <<= l
+		r.n -= l
+		return uint8(e), nil
+	}
+	for l := lutBits + 1; l <= 16; l++ {
+		code := int32(r.acc >> (64 - l))
+		if code <= h.maxCode[l] {
+			r.acc <<= uint(l)
+			r.n -= uint(l)
+			return h.vals[h.valPtr[l]+code-h.minCode[l]], nil
+		}
+	}
+	return 0, formatError("bad Huffman code")
+}
+
+// receive reads an s-bit magnitude and sign-extends it (T.81 F.2.2.1).
+func (r *bitReader) receive(s uint8) int32 {
+	if s == 0 {
+		return 0
+	}
+	if r.n < uint(s) {
+		r.fill()
+	}
+	v := int32(r.acc >> (64 - s))
+	r.acc <<= s
+	r.n -= uint(s)
+	if v < 1<<(s-1) {
+		v += -1<<s + 1
+	}
+	return v
+}
+
+// readBlock decodes one block into b (natural order, dequantized by q) and
+// returns the new DC predictor. ac reports whether any AC coefficient is set.
+func (r *bitReader) readBlock(b *[64]int32, q *[64]int32, prevDC int32, dc, act *huffDecoder) (pred int32, ac bool, err error) {
+	*b = [64]int32{}
+	t, err := r.decodeHuff(dc)
+	if err != nil {
+		return 0, false, err
+	}
+	if t > 11 {
+		return 0, false, formatError("bad DC size")
+	}
+	pred = prevDC + r.receive(t)
+	b[0] = pred * q[0]
+	for k := 1; k < 64; k++ {
+		rs, err := r.decodeHuff(act)
+		if err != nil {
+			return 0, false, err
+		}
+		run, s := int(rs>>4), rs&15
+		if s == 0 {
+			if run != 15 {
+				break
+			}
+			k += 15
+			continue
+		}
+		k += run
+		if k > 63 || s > 10 {
+			return 0, false, formatError("bad AC coefficient")
+		}
+		z := zigzag[k]
+		b[z] = r.receive(s) * q[z]
+		ac = true
+	}
+	return pred, ac, nil
+}
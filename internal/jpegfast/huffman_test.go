@@ -0,0 +1,123 @@
+package jpegfast
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testImage is a smooth gradient with a few edges, so every block has
+// some AC energy but nothing a good encoder should lose much of.
+func testImage(w, h int) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), uint8((x + y) * 127 / (w + h)), 255}
+			if (x/24+y/24)%5 == 0 {
+				c.R, c.B = c.B, c.R
+			}
+			m.SetRGBA(x, y, c)
+		}
+	}
+	return m
+}
+
+func encodeTest(t testing.TB, img image.Image, cfg EncodeConfig) []byte {
+	t.Helper()
+	enc, err := NewEncoder(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dst []byte
+	if cfg.Flags&FlagNoRealloc != 0 {
+		b := img.Bounds()
+		dst = make([]byte, 0, BufSize(b.Dx(), b.Dy(), cfg.Subsampling))
+	}
+	out, err := enc.AppendEncode(dst, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// overSubscribeDHT rewrites the first table of the first DHT segment so
+// all of its symbols have 1-bit codes, and moves the segment up to follow
+// SOI, where the header parser meets it before the frame header.
+func overSubscribeDHT(t *testing.T, jpg []byte) []byte {
+	t.Helper()
+	i := bytes.Index(jpg, []byte{0xFF, 0xC4})
+	if i < 0 {
+		t.Fatal("no DHT segment")
+	}
+	n := 2 + int(jpg[i+2])<<8 | int(jpg[i+3])
+	dht := bytes.Clone(jpg[i : i+n])
+	counts := dht[5:21]
+	total := 0
+	for k, c := range counts {
+		total += int(c)
+		counts[k] = 0
+	}
+	counts[0] = uint8(total)
+	out := append([]byte{0xFF, 0xD8}, dht...)
+	out = append(out, jpg[2:i]...)
+	return append(out, jpg[i+n:]...)
+}
+
+func TestOverSubscribedDHT(t *testing.T) {
+	bad := overSubscribeDHT(t, encodeTest(t, testImage(64, 48), EncodeConfig{Quality: 80, Subsampling: Subsample420}))
+	paint := func(tile *image.RGBA) {}
+	calls := map[string]func() error{
+		"DecodeHeader": func() error { _, err := DecodeHeader(bad); return err },
+		"Decode":       func() error { _, err := Decode(bad, DecodeOptions{}); return err },
+		"AppendTransform": func() error {
+			_, err := AppendTransform(nil, bad, Transform{Rotate: 90})
+			return err
+		},
+		"AppendPatch": func() error {
+			_, err := AppendPatch(nil, bad, []image.Rectangle{image.Rect(0, 0, 16, 16)}, paint)
+			return err
+		},
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, ErrFormat) {
+			t.Errorf("%s: got %v, want ErrFormat", name, err)
+		}
+	}
+}
+
+func TestCheckHuffCounts(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts [16]uint8
+		ok     bool
+	}{
+		{"empty", [16]uint8{}, true},
+		{"two 1-bit codes", [16]uint8{2}, true},
+		{"three 1-bit codes", [16]uint8{3}, false},
+		{"full at 16 bits", [16]uint8{0, 0, 0, 0, 0, 0, 0, 0, 255, 0, 0, 0, 0, 0, 0, 1}, true},
+		{"one past full", [16]uint8{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 3}, false},
+		{"255 16-bit codes", [16]uint8{15: 255}, true},
+		{"too many symbols", [16]uint8{14: 128, 15: 129}, false},
+	}
+	for _, tt := range tests {
+		if err := checkHuffCounts(&tt.counts); (err == nil) != tt.ok {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	good := encodeTest(f, testImage(40, 24), EncodeConfig{Quality: 75, Subsampling: Subsample420})
+	f.Add(good)
+	f.Add(good[:len(good)/2])
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Analyze(data)
+		if _, err := Decode(data, DecodeOptions{}); err != nil {
+			return
+		}
+		AppendTransform(nil, data, Transform{Rotate: 90})
+		AppendPatch(nil, data, []image.Rectangle{image.Rect(0, 0, 8, 8)}, func(*image.RGBA) {})
+	})
+}
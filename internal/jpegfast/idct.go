@@ -0,0 +1,186 @@
+package jpegfast
+
+import "math"
+
+// Inverse DCTs take dequantized coefficients in natural order and write
+// n x n clamped samples to dst with the given stride, where n = 8/scale.
+
+func clampSample(v int32) byte {
+	return byte(min(max(v, 0), 255))
+}
+
+// idctDC fills an n x n block from the DC term alone, which is also exactly
+// the 1/8-scale result.
+func idctDC(b *[64]int32, dst []byte, stride, n int) {
+	v := clampSample((b[0]+4)>>3 + 128)
+	for y := 0; y < n; y++ {
+		row := dst[y*stride : y*stride+n]
+		for x := range row {
+			row[x] = v
+		}
+	}
+}
+
+// idctAccurate is the integer LLM inverse DCT (IJG "islow"), the inverse of
+// fdctAccurate.
+func idctAccurate(b *[64]int32, dst []byte, stride int) {
+	var ws [64]int32
+	for x := 0; x < 8; x++ {
+		if b[8+x]|b[16+x]|b[24+x]|b[32+x]|b[40+x]|b[48+x]|b[56+x] == 0 {
+			dc := b[x] << pass1Bits
+			for y := 0; y < 64; y += 8 {
+				ws[y+x] = dc
+			}
+			continue
+		}
+		z2, z3 := b[16+x], b[48+x]
+		z1 := (z2 + z3) * fix_0_541196100
+		tmp2 := z1 - z3*fix_1_847759065
+		tmp3 := z1 + z2*fix_0_765366865
+		z2, z3 = b[x], b[32+x]
+		tmp0 := (z2 + z3) << constBits
+		tmp1 := (z2 - z3) << constBits
+		tmp10, tmp13 := tmp0+tmp3, tmp0-tmp3
+		tmp11, tmp12 := tmp1+tmp2, tmp1-tmp2
+
+		tmp0, tmp1, tmp2, tmp3 = b[56+x], b[40+x], b[24+x], b[8+x]
+		o0, o1, o2, o3 := idctOdd(tmp0, tmp1, tmp2, tmp3)
+
+		const sh, rnd = constBits - pass1Bits, 1 << (constBits - pass1Bits - 1)
+		ws[x] = (tmp10 + o3 + rnd) >> sh
+		ws[56+x] = (tmp10 - o3 + rnd) >> sh
+		ws[8+x] = (tmp11 + o2 + rnd) >> sh
+		ws[48+x] = (tmp11 - o2 + rnd) >> sh
+		ws[16+x] = (tmp12 + o1 + rnd) >> sh
+		ws[40+x] = (tmp12 - o1 + rnd) >> sh
+		ws[24+x] = (tmp13 + o0 + rnd) >> sh
+		ws[32+x] = (tmp13 - o0 + rnd) >> sh
+	}
+	for y := 0; y < 8; y++ {
+		s := ws[y*8 : y*8+8 : y*8+8]
+		out := dst[y*stride : y*stride+8 : y*stride+8]
+		z2, z3 := s[2], s[6]
+		z1 := (z2 + z3) * fix_0_541196100
+		tmp2 := z1 - z3*fix_1_847759065
+		tmp3 := z1 + z2*fix_0_765366865
+		tmp0 := (s[0] + s[4]) << constBits
+		tmp1 := (s[0] - s[4]) << constBits
+		tmp10, tmp13 := tmp0+tmp3, tmp0-tmp3
+		tmp11, tmp12 := tmp1+tmp2, tmp1-tmp2
+		o0, o1, o2, o3 := idctOdd(s[7], s[5], s[3], s[1])
+
+		const sh, rnd = constBits + pass1Bits + 3, 1 << (constBits + pass1Bits + 2)
+		out[0] = clampSample((tmp10+o3+rnd)>>sh + 128)
+		out[7] = clampSample((tmp10-o3+rnd)>>sh + 128)
+		out[1] = clampSample((tmp11+o2+rnd)>>sh + 128)
+		out[6] = clampSample((tmp11-o2+rnd)>>sh + 128)
+		out[2] = clampSample((tmp12+o1+rnd)>>sh + 128)
+		out[5] = clampSample((tmp12-o1+rnd)>>sh + 128)
+		out[3] = clampSample((tmp13+o0+rnd)>>sh + 128)
+		out[4] = clampSample((tmp13-o0+rnd)>>sh + 128)
+	}
+}
+
+// idctOdd is the odd half of the LLM inverse DCT for inputs 7, 5, 3 and 1.
+func idctOdd(tmp0, tmp1, tmp2, tmp3 int32) (int32, int32, int32, int32) {
+	z1 := tmp0 + tmp3
+	z2 := tmp1 + tmp2
+	z3 := tmp0 + tmp2
+	z4 := tmp1 + tmp3
+	z5 := (z3 + z4) * fix_1_175875602
+	tmp0 *= fix_0_298631336
+	tmp1 *= fix_2_053119869
+	tmp2 *= fix_3_072711026
+	tmp3 *= fix_1_501321110
+	z1 *= -fix_0_899976223
+	z2 *= -fix_2_562915447
+	z3 = z3*-fix_1_961570560 + z5
+	z4 = z4*-fix_0_390180644 + z5
+	return tmp0 + z1 + z3, tmp1 + z2 + z4, tmp2 + z2 + z3, tmp3 + z1 + z4
+}
+
+// reducedIDCT[i] holds the 1-D basis for 4 (i == 0) or 2 (i == 1) outputs
+// per axis: c(u)/2 * cos((2x+1)u*pi/16) averaged over the 8/n pixels x that
+// output k covers, in 13-bit fixed point. Averaging makes the result an
+// exact box downscale of the full IDCT, and zeroes the u == 4 column (and
+// u == 2, 6 for n == 2) so those inputs are never read.
+var reducedIDCT = func() (m [2][2][8]int32) {
+	for i, n := range [2]int{4, 2} {
+		s := 8 / n
+		for k := 0; k < n/2; k++ {
+			for u := 0; u < 8; u++ {
+				c := 0.5
+				if u == 0 {
+					c = 0.5 / math.Sqrt2
+				}
+				var v float64
+				for x := k * s; x < (k+1)*s; x++ {
+					v += math.Cos(float64((2*x+1)*u) * math.Pi / 16)
+				}
+				m[i][k][u] = int32(math.Round(c * v / float64(s) * (1 << constBits)))
+			}
+		}
+	}
+	return m
+}()
+
+// Even basis rows are symmetric and odd ones antisymmetric about the block
+// centre, so only the first n/2 rows are stored and outputs come in pairs.
+
+// idct4 produces a 4x4 block, each sample the mean of a 2x2 pixel group.
+func idct4(b *[64]int32, dst []byte, stride int) {
+	m := &reducedIDCT[0]
+	var ws [4 * 8]int32
+	const sh1, rnd1 = constBits - pass1Bits, 1 << (constBits - pass1Bits - 1)
+	for x := 0; x < 8; x++ {
+		if x == 4 {
+			continue
+		}
+		var in [8]int32
+		for u := range in {
+			in[u] = b[u*8+x]
+		}
+		o := idct4Pass(m, &in)
+		ws[x] = (o[0] + rnd1) >> sh1
+		ws[8+x] = (o[1] + rnd1) >> sh1
+		ws[16+x] = (o[2] + rnd1) >> sh1
+		ws[24+x] = (o[3] + rnd1) >> sh1
+	}
+	const sh2, rnd2 = constBits + pass1Bits, 1 << (constBits + pass1Bits - 1)
+	for y := 0; y < 4; y++ {
+		o := idct4Pass(m, (*[8]int32)(ws[y*8:y*8+8]))
+		out := dst[y*stride : y*stride+4 : y*stride+4]
+		for k, v := range o {
+			out[k] = clampSample((v+rnd2)>>sh2 + 128)
+		}
+	}
+}
+
+func idct4Pass(m *[2][8]int32, s *[8]int32) [4]int32 {
+	e0 := m[0][0]*s[0] + m[0][2]*s[2] + m[0][6]*s[6]
+	e1 := m[1][0]*s[0] + m[1][2]*s[2] + m[1][6]*s[6]
+	o0 := m[0][1]*s[1] + m[0][3]*s[3] + m[0][5]*s[5] + m[0][7]*s[7]
+	o1 := m[1][1]*s[1] + m[1][3]*s[3] + m[1][5]*s[5] + m[1][7]*s[7]
+	return [4]int32{e0 + o0, e1 + o1, e1 - o1, e0 - o0}
+}
+
+// idct2 produces a 2x2 block, each sample the mean of a 4x4 pixel group.
+func idct2(b *[64]int32, dst []byte, stride int) {
+	m := &reducedIDCT[1][0]
+	var ws [2 * 8]int32
+	const sh1, rnd1 = constBits - pass1Bits, 1 << (constBits - pass1Bits - 1)
+	for _, x := range [...]int{0, 1, 3, 5, 7} {
+		e := m[0] * b[x]
+		o := m[1]*b[8+x] + m[3]*b[24+x] + m[5]*b[40+x] + m[7]*b[56+x]
+		ws[x] = (e + o + rnd1) >> sh1
+		ws[8+x] = (e - o + rnd1) >> sh1
+	}
+	const sh2, rnd2 = constBits + pass1Bits, 1 << (constBits + pass1Bits - 1)
+	for y := 0; y < 2; y++ {
+		s := ws[y*8 : y*8+8 : y*8+8]
+		e := m[0] * s[0]
+		o := m[1]*s[1] + m[3]*s[3] + m[5]*s[5] + m[7]*s[7]
+		dst[y*stride] = clampSample((e+o+rnd2)>>sh2 + 128)
+		dst[y*stride+1] = clampSample((e-o+rnd2)>>sh2 + 128)
+	}
+}
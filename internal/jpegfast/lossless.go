@@ -0,0 +1,187 @@
+package jpegfast
+
+import (
+	"errors"
+	"image"
+)
+
+// ErrNotPerfect is returned by AppendTransform with Transform.Perfect set
+// when the result would differ from the exact geometric transform.
+var ErrNotPerfect = errors.New("transform is not MCU-aligned")
+
+// Transform describes a lossless rearrangement of a JPEG's DCT blocks.
+// Rotation is applied first, then mirroring, then the crop.
+type Transform struct {
+	Rotate int  // clockwise degrees: 0, 90, 180 or 270
+	FlipH  bool // mirror left-right
+	FlipV  bool // mirror top-bottom
+	// Crop selects a rectangle of the rotated, mirrored picture; empty keeps
+	// all of it. Its top-left corner is moved up and left to the MCU grid.
+	Crop image.Rectangle
+	// Perfect rejects transforms that would have to trim partial MCUs from a
+	// mirrored edge or move the crop corner, returning ErrNotPerfect.
+	Perfect bool
+}
+
+// normalize rewrites t as mirror-then-transpose in source coordinates:
+// the source is mirrored along x and/or y, then optionally transposed.
+func (t Transform) normalize() (fx, fy, transpose bool) {
+	switch t.Rotate {
+	case 90:
+		fy, transpose = true, true
+	case 180:
+		fx, fy = true, true
+	case 270:
+		fx, transpose = true, true
+	}
+	// mirroring an output axis mirrors the source axis it came from
+	if t.FlipH {
+		if transpose {
+			fy = !fy
+		} else {
+			fx = !fx
+		}
+	}
+	if t.FlipV {
+		if transpose {
+			fx = !fx
+		} else {
+			fy = !fy
+		}
+	}
+	return fx, fy, transpose
+}
+
+// AppendTransform appends a transformed copy of the baseline JPEG src to
+// dst without decoding pixels, so no generation loss is introduced. The
+// output is re-coded with standard Huffman tables and the source's
+// quantization tables. Partial MCUs along a mirrored edge cannot be moved
+// and are trimmed, as jpegtran -trim does, unless t.Perfect is set.
+func AppendTransform(dst, src []byte, t Transform) ([]byte, error) {
+	switch t.Rotate {
+	case 0, 90, 180, 270:
+	default:
+		return dst, ErrInvalidInput
+	}
+	d := decoderPool.Get().(*decoder)
+	defer decoderPool.Put(d)
+	if err := d.parse(src, false); err != nil {
+		return dst, err
+	}
+	defer func() { d.br = bitReader{} }()
+
+	fx, fy, tr := t.normalize()
+	mw, mh := 8*d.hmax, 8*d.vmax
+	sw, sh := d.width, d.height
+	if fx {
+		sw -= sw % mw
+	}
+	if fy {
+		sh -= sh % mh
+	}
+	if sw == 0 || sh == 0 {
+		return dst, ErrNotPerfect
+	}
+	if t.Perfect && (sw != d.width || sh != d.height) {
+		return dst, ErrNotPerfect
+	}
+
+	ow, oh, omw, omh := sw, sh, mw, mh
+	if tr {
+		ow, oh, omw, omh = sh, sw, mh, mw
+	}
+	crop := image.Rect(0, 0, ow, oh)
+	if !t.Crop.Empty() {
+		crop = t.Crop.Intersect(crop)
+		if crop.Empty() {
+			return dst, ErrInvalidInput
+		}
+	}
+	aligned := image.Pt(crop.Min.X-crop.Min.X%omw, crop.Min.Y-crop.Min.Y%omh)
+	if t.Perfect && aligned != crop.Min {
+		return dst, ErrNotPerfect
+	}
+	crop.Min = aligned
+
+	in := coefPool.Get().(*coefImage)
+	defer coefPool.Put(in)
+	if err := d.readCoefficients(in); err != nil {
+		return dst, err
+	}
+
+	out := coefPool.Get().(*coefImage)
+	defer coefPool.Put(out)
+	out.ncomp = in.ncomp
+	out.quant = in.quant
+	for i := 0; i < in.ncomp; i++ {
+		p := &in.comp[i]
+		out.comp[i].h, out.comp[i].v, out.comp[i].tq = p.h, p.v, p.tq
+		if tr {
+			out.comp[i].h, out.comp[i].v = p.v, p.h
+		}
+	}
+	if tr {
+		for q := range out.quant {
+			transposeBlock(&out.quant[q])
+		}
+	}
+	out.setSize(crop.Dx(), crop.Dy())
+
+	for i := 0; i < out.ncomp; i++ {
+		sp, op := &in.comp[i], &out.comp[i]
+		// blocks of the source that hold real, untrimmed pixels along mirrored axes
+		nbx, nby := sw/mw*sp.h, sh/mh*sp.v
+		offX, offY := crop.Min.X/omw*op.h, crop.Min.Y/omh*op.v
+		for by := 0; by < op.bh; by++ {
+			for bx := 0; bx < op.bw; bx++ {
+				x, y := bx+offX, by+offY
+				if tr {
+					x, y = y, x
+				}
+				if fx {
+					x = nbx - 1 - x
+				}
+				if fy {
+					y = nby - 1 - y
+				}
+				b := op.block(bx, by)
+				if x < 0 || y < 0 || x >= sp.bw || y >= sp.bh {
+					*b = [64]int32{}
+					continue
+				}
+				*b = *sp.block(x, y)
+				flipBlock(b, fx, fy)
+				if tr {
+					transposeBlock(b)
+				}
+			}
+		}
+	}
+
+	w := bitWriter{buf: dst}
+	out.encode(&w)
+	return w.buf, w.err
+}
+
+// flipBlock mirrors a block's pixels by negating the coefficients of odd
+// horizontal (fx) or vertical (fy) frequency.
+func flipBlock(b *[64]int32, fx, fy bool) {
+	if !fx && !fy {
+		return
+	}
+	for i := range b {
+		oddX := fx && i&1 != 0
+		oddY := fy && i&8 != 0
+		if oddX != oddY {
+			b[i] = -b[i]
+		}
+	}
+}
+
+func transposeBlock(b *[64]int32) {
+	for r := 0; r < 8; r++ {
+		for c := r + 1; c < 8; c++ {
+			b[r*8+c], b[c*8+r] = b[c*8+r], b[r*8+c]
+		}
+	}
+}
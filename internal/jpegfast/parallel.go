@@ -0,0 +1,73 @@
+package jpegfast
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// minStripRows is the smallest strip worth handing to a worker; below it the
+// restart markers and goroutine hand-off cost more than they save.
+const minStripRows = 2
+
+// stripPlan splits mcusY MCU rows into strips for the given worker count.
+// It returns the rows per strip and the strip count; rows is 0 when the image
+// is too small to split. The restart interval (rows * mcusX) must fit DRI's
+// 16-bit field.
+func stripPlan(mcusX, mcusY, workers int) (rows, strips int) {
+	if workers < 2 || mcusY < 2*minStripRows {
+		return 0, 1
+	}
+	rows = max((mcusY+workers-1)/workers, minStripRows)
+	if rows*mcusX > 0xFFFF {
+		rows = max(0xFFFF/mcusX, 1)
+	}
+	strips = (mcusY + rows - 1) / rows
+	if strips < 2 {
+		return 0, 1
+	}
+	return rows, strips
+}
+
+// encodeParallel codes the scan as independent strips of whole MCU rows on
+// up to workers goroutines. Each strip is a restart interval, so the strips
+// are stitched together with RSTn markers into one baseline JPEG.
+func (e *Encoder) encodeParallel(enc *encoder, w *bitWriter, src sampler, sc *scratch, rows, strips int) {
+	enc.restart = rows * enc.mcusX
+	enc.writeHeaders(w)
+
+	if cap(sc.strips) < strips {
+		sc.strips = make([][]byte, strips)
+	}
+	bufs := sc.strips[:strips]
+
+	var next atomic.Int32
+	var wg sync.WaitGroup
+	workers := min(enc.cfg.Workers, strips)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ws := e.pool.Get().(*scratch)
+			defer e.pool.Put(ws)
+			for {
+				s := int(next.Add(1)) - 1
+				if s >= strips {
+					return
+				}
+				sw := bitWriter{buf: bufs[s][:0]}
+				enc.encodeRows(&sw, src, s*rows, min((s+1)*rows, enc.mcusY), &ws.mcu)
+				sw.pad()
+				bufs[s] = sw.buf
+			}
+		}()
+	}
+	wg.Wait()
+
+	for s, b := range bufs {
+		w.writeBytes(b...)
+		if s < strips-1 {
+			w.writeBytes(0xFF, 0xD0+byte(s%8))
+		}
+	}
+	w.writeBytes(0xFF, 0xD9)
+}
@@ -0,0 +1,110 @@
+package jpegfast
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestStripPlan(t *testing.T) {
+	tests := []struct {
+		mcusX, mcusY, workers int
+		rows, strips          int
+	}{
+		{120, 68, 1, 0, 1},
+		{120, 68, 4, 17, 4},
+		{120, 68, 3, 23, 3},
+		{120, 68, 64, 2, 34},  // strips no smaller than minStripRows
+		{120, 3, 4, 0, 1},     // too short to split
+		{5000, 40, 2, 13, 4},  // DRI interval capped at 0xFFFF MCUs
+		{0x10000, 4, 2, 1, 4}, // a row wider than DRI allows still splits by row
+	}
+	for _, tt := range tests {
+		rows, strips := stripPlan(tt.mcusX, tt.mcusY, tt.workers)
+		if rows != tt.rows || strips != tt.strips {
+			t.Errorf("stripPlan(%d, %d, %d) = %d, %d; want %d, %d",
+				tt.mcusX, tt.mcusY, tt.workers, rows, strips, tt.rows, tt.strips)
+		}
+	}
+}
+
+// TestParallelEncode checks that striped output is a valid baseline JPEG
+// for image/jpeg and decodes to the same pixels as a serial encode, across
+// worker counts and heights that leave a short last strip or a partial MCU.
+func TestParallelEncode(t *testing.T) {
+	for _, sub := range []Subsampling{Subsample444, Subsample420, Subsample411, SubsampleGray} {
+		for _, h := range []int{64, 93, 117, 250} {
+			src := testImage(77, h)
+			serial, err := jpeg.Decode(bytes.NewReader(encodeTest(t, src, EncodeConfig{Quality: 80, Subsampling: sub})))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, workers := range []int{2, 3, 4, 7, 16} {
+				t.Run(fmt.Sprintf("sub%d/h%d/w%d", sub, h, workers), func(t *testing.T) {
+					cfg := EncodeConfig{Quality: 80, Subsampling: sub, Workers: workers}
+					jpg := encodeTest(t, src, cfg)
+					_, mh := sub.MCUSize()
+					_, strips := stripPlan(1, (h+mh-1)/mh, workers)
+					info, err := Analyze(jpg)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if strips > 1 && info.Restart == 0 {
+						t.Error("no DRI segment")
+					}
+					if n := countRST(jpg); n != strips-1 {
+						t.Errorf("%d RST markers, want %d", n, strips-1)
+					}
+					got, err := jpeg.Decode(bytes.NewReader(jpg))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if p := psnr(serial, got); !math.IsInf(p, 1) {
+						t.Errorf("differs from serial encode: PSNR %.2f dB", p)
+					}
+				})
+			}
+		}
+	}
+}
+
+// countRST counts the RSTn markers in the entropy-coded data of jpg.
+func countRST(jpg []byte) int {
+	n := 0
+	for i := 0; i+1 < len(jpg); i++ {
+		if jpg[i] == 0xFF && jpg[i+1] >= 0xD0 && jpg[i+1] <= 0xD7 {
+			n++
+		}
+	}
+	return n
+}
+
+func BenchmarkEncode1080p(b *testing.B) {
+	src := testImage(1920, 1080)
+	b.Run("image/jpeg", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(src.Pix)))
+		for b.Loop() {
+			if err := jpeg.Encode(io.Discard, src, &jpeg.Options{Quality: 80}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("jpegfast/workers=%d", workers), func(b *testing.B) {
+			enc, _ := NewEncoder(EncodeConfig{Quality: 80, Subsampling: Subsample420, Workers: workers})
+			dst := make([]byte, 0, BufSize(1920, 1080, Subsample420))
+			b.ReportAllocs()
+			b.SetBytes(int64(len(src.Pix)))
+			for b.Loop() {
+				var err error
+				if dst, err = enc.AppendEncode(dst[:0], src); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
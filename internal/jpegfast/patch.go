@@ -0,0 +1,210 @@
+package jpegfast
+
+import (
+	"image"
+	"sync"
+)
+
+// AppendPatch appends a copy of the baseline JPEG src to dst in which only
+// the MCUs overlapping regions are decoded, handed to paint and re-encoded;
+// every other block keeps its quantized coefficients, so the cost of
+// annotating a frame follows the painted area rather than the frame size.
+//
+// paint is called once per horizontal run of touched MCUs with a tile in
+// frame coordinates holding the decoded pixels. Only pixels that paint
+// changes are re-sampled, and only blocks containing such pixels are
+// re-quantized, using the source's own tables.
+func AppendPatch(dst, src []byte, regions []image.Rectangle, paint func(tile *image.RGBA)) ([]byte, error) {
+	d := decoderPool.Get().(*decoder)
+	defer decoderPool.Put(d)
+	if err := d.parse(src, false); err != nil {
+		return dst, err
+	}
+	ci := coefPool.Get().(*coefImage)
+	defer coefPool.Put(ci)
+	err := d.readCoefficients(ci)
+	d.br = bitReader{}
+	if err != nil {
+		return dst, err
+	}
+
+	ps := patchPool.Get().(*patchScratch)
+	defer patchPool.Put(ps)
+	ps.reset(ci)
+	frame := image.Rect(0, 0, ci.width, ci.height)
+	mw, mh := 8*ci.hmax, 8*ci.vmax
+	for _, r := range regions {
+		r = r.Canon().Intersect(frame)
+		if r.Empty() {
+			continue
+		}
+		for my := r.Min.Y / mh; my <= (r.Max.Y-1)/mh; my++ {
+			for mx := r.Min.X / mw; mx <= (r.Max.X-1)/mw; mx++ {
+				ps.dirty[my*ci.mcusX+mx] = true
+			}
+		}
+	}
+	for my := 0; my < ci.mcusY; my++ {
+		row := ps.dirty[my*ci.mcusX : (my+1)*ci.mcusX]
+		for mx := 0; mx < len(row); {
+			if !row[mx] {
+				mx++
+				continue
+			}
+			end := mx
+			for end < len(row) && row[end] {
+				end++
+			}
+			ps.patchRun(ci, mx, end, my, frame, paint)
+			mx = end
+		}
+	}
+
+	w := bitWriter{buf: dst}
+	ci.encode(&w)
+	return w.buf, w.err
+}
+
+var patchPool = sync.Pool{New: func() any { return new(patchScratch) }}
+
+// patchScratch holds the dirty-MCU map and the pixel buffers of one run.
+type patchScratch struct {
+	dirty  []bool
+	planes [3][]byte // decoded samples of the run, per component
+	pix    []byte    // RGBA tile handed to paint
+	orig   []byte    // tile before painting
+	quant  [4]quantTable
+	qready [4]bool
+	blk    [64]int32
+}
+
+func (ps *patchScratch) reset(ci *coefImage) {
+	n := ci.mcusX * ci.mcusY
+	if cap(ps.dirty) < n {
+		ps.dirty = make([]bool, n)
+	}
+	ps.dirty = ps.dirty[:n]
+	clear(ps.dirty)
+	ps.qready = [4]bool{}
+}
+
+func grow(b []byte, n int) []byte {
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+	return b[:n]
+}
+
+// patchRun decodes MCUs [mx0, mx1) of row my, lets paint modify them and
+// re-codes the blocks that changed.
+func (ps *patchScratch) patchRun(ci *coefImage, mx0, mx1, my int, frame image.Rectangle, paint func(*image.RGBA)) {
+	mw, mh := 8*ci.hmax, 8*ci.vmax
+	tw := (mx1 - mx0) * mw
+	var strides [3]int
+	for i := 0; i < ci.ncomp; i++ {
+		p := &ci.comp[i]
+		strides[i] = (mx1 - mx0) * p.h * 8
+		ps.planes[i] = grow(ps.planes[i], strides[i]*p.v*8)
+		for by := 0; by < p.v; by++ {
+			for bx := 0; bx < (mx1-mx0)*p.h; bx++ {
+				ps.blk = *p.block(mx0*p.h+bx, my*p.v+by)
+				dequantize(&ps.blk, &ci.quant[p.tq])
+				idctAccurate(&ps.blk, ps.planes[i][by*8*strides[i]+bx*8:], strides[i])
+			}
+		}
+	}
+
+	// chroma is replicated: painted pixels are overwritten anyway and the
+	// others keep their original samples below
+	ps.pix = grow(ps.pix, tw*mh*4)
+	for y := 0; y < mh; y++ {
+		for x := 0; x < tw; x++ {
+			o := (y*tw + x) * 4
+			yy := int32(ps.planes[0][y*strides[0]+x])
+			if ci.ncomp == 1 {
+				ps.pix[o], ps.pix[o+1], ps.pix[o+2], ps.pix[o+3] = byte(yy), byte(yy), byte(yy), 0xFF
+				continue
+			}
+			c := (y/ci.vmax)*strides[1] + x/ci.hmax
+			r, g, b := ycbcrToRGB(yy, int32(ps.planes[1][c])-128, int32(ps.planes[2][c])-128)
+			ps.pix[o], ps.pix[o+1], ps.pix[o+2], ps.pix[o+3] = r, g, b, 0xFF
+		}
+	}
+	ps.orig = grow(ps.orig, len(ps.pix))
+	copy(ps.orig, ps.pix)
+
+	origin := image.Pt(mx0*mw, my*mh)
+	tile := &image.RGBA{
+		Pix:    ps.pix,
+		Stride: tw * 4,
+		Rect:   image.Rectangle{Min: origin, Max: origin.Add(image.Pt(tw, mh))}.Intersect(frame),
+	}
+	paint(tile)
+
+	changed := func(x, y int) bool {
+		o := (y*tw + x) * 4
+		return ps.pix[o] != ps.orig[o] || ps.pix[o+1] != ps.orig[o+1] || ps.pix[o+2] != ps.orig[o+2]
+	}
+	for i := 0; i < ci.ncomp; i++ {
+		p := &ci.comp[i]
+		// pixels per sample of this component
+		sx, sy := ci.hmax/p.h, ci.vmax/p.v
+		for by := 0; by < p.v; by++ {
+			for bx := 0; bx < (mx1-mx0)*p.h; bx++ {
+				touched := false
+				for j := 0; j < 64; j++ {
+					sampleTouched := false
+					x0, y0 := (bx*8+j%8)*sx, (by*8+j/8)*sy
+					var sum int32
+					for dy := 0; dy < sy; dy++ {
+						for dx := 0; dx < sx; dx++ {
+							x, y := x0+dx, y0+dy
+							sampleTouched = sampleTouched || changed(x, y)
+							o := (y*tw + x) * 4
+							sum += sampleOf(i, ps.pix[o], ps.pix[o+1], ps.pix[o+2])
+						}
+					}
+					if sampleTouched {
+						touched = true
+						n := int32(sx * sy)
+						ps.blk[j] = (sum+n/2+128*n)/n - 128
+					} else {
+						ps.blk[j] = int32(ps.planes[i][(by*8+j/8)*strides[i]+bx*8+j%8]) - 128
+					}
+				}
+				if !touched {
+					continue
+				}
+				if !ps.qready[p.tq] {
+					var q [64]uint16
+					for k, v := range ci.quant[p.tq] {
+						q[k] = uint16(max(v, 1))
+					}
+					ps.quant[p.tq] = newQuantTable(&q, false)
+					ps.qready[p.tq] = true
+				}
+				fdctAccurate(&ps.blk)
+				quantize(&ps.blk, &ps.quant[p.tq])
+				*p.block(mx0*p.h+bx, my*p.v+by) = ps.blk
+			}
+		}
+	}
+}
+
+// sampleOf returns component i (Y, Cb or Cr) of an RGB pixel, level-shifted.
+func sampleOf(i int, r, g, b byte) int32 {
+	y, cb, cr := rgbToYCbCr(int32(r), int32(g), int32(b))
+	switch i {
+	case 1:
+		return cb
+	case 2:
+		return cr
+	}
+	return y
+}
+
+func dequantize(b *[64]int32, q *[64]int32) {
+	for i := range b {
+		b[i] *= q[i]
+	}
+}
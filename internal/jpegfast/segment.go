@@ -0,0 +1,67 @@
+package jpegfast
+
+import "bytes"
+
+// MaxSegmentPayload is the largest payload a marker segment can carry.
+const MaxSegmentPayload = 0xFFFF - 2
+
+// InsertSegment appends src to dst with a marker segment holding payload
+// inserted after SOI and any leading APP0/APP1 segments, which JFIF and
+// EXIF readers expect to come first. Entropy-coded data is copied as is.
+func InsertSegment(dst, src []byte, marker byte, payload []byte) ([]byte, error) {
+	if len(payload) > MaxSegmentPayload {
+		return dst, ErrInvalidInput
+	}
+	if len(src) < 4 || src[0] != 0xFF || src[1] != 0xD8 {
+		return dst, formatError("missing SOI marker")
+	}
+	at := 2
+	for at+4 <= len(src) && src[at] == 0xFF && (src[at+1] == 0xE0 || src[at+1] == 0xE1) {
+		n := int(src[at+2])<<8 | int(src[at+3])
+		if at+2+n > len(src) {
+			return dst, formatError("truncated segment")
+		}
+		at += 2 + n
+	}
+	n := len(payload) + 2
+	dst = append(dst, src[:at]...)
+	dst = append(dst, 0xFF, marker, byte(n>>8), byte(n))
+	dst = append(dst, payload...)
+	return append(dst, src[at:]...), nil
+}
+
+// FindSegment returns the payload, without prefix, of the first marker
+// segment before the image data whose payload starts with prefix, or nil.
+func FindSegment(data []byte, marker byte, prefix []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			pos++
+			continue
+		}
+		m := data[pos+1]
+		switch {
+		case m == 0xFF:
+			pos++
+			continue
+		case m == 0xDA || m == 0xD9:
+			return nil
+		case m == 0x01 || m >= 0xD0 && m <= 0xD8:
+			pos += 2
+			continue
+		}
+		n := int(data[pos+2])<<8 | int(data[pos+3])
+		if n < 2 || pos+2+n > len(data) {
+			return nil
+		}
+		seg := data[pos+4 : pos+2+n]
+		if m == marker && bytes.HasPrefix(seg, prefix) {
+			return seg[len(prefix):]
+		}
+		pos += 2 + n
+	}
+	return nil
+}
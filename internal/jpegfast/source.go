@@ -0,0 +1,166 @@
+package jpegfast
+
+import "image"
+
+// maxMCUPixels is the largest MCU area of any supported subsampling
+// (16x16 for 4:2:0, 32x8 for 4:1:1).
+const maxMCUPixels = 256
+
+// sampler produces level-shifted Y, Cb and Cr samples for one MCU at full
+// resolution. Pixels past the right and bottom edges replicate the edge.
+// cb and cr are nil when only luma is needed.
+type sampler interface {
+	load(x0, y0, mw, mh int, y, cb, cr []int32)
+}
+
+// JFIF RGB -> YCbCr in 16-bit fixed point, producing level-shifted values.
+func rgbToYCbCr(r, g, b int32) (int32, int32, int32) {
+	y := (19595*r+38470*g+7471*b+1<<15)>>16 - 128
+	cb := (-11056*r - 21712*g + 32768*b + 1<<15) >> 16
+	cr := (32768*r - 27440*g - 5328*b + 1<<15) >> 16
+	return y, cb, cr
+}
+
+func rgbToY(r, g, b int32) int32 {
+	return (19595*r+38470*g+7471*b+1<<15)>>16 - 128
+}
+
+// bgrSampler reads packed 24-bit BGR pixels.
+type bgrSampler struct {
+	pix    []byte
+	stride int
+	w, h   int
+}
+
+func (s *bgrSampler) load(x0, y0, mw, mh int, yy, cb, cr []int32) {
+	for j := 0; j < mh; j++ {
+		sy := min(y0+j, s.h-1)
+		row := s.pix[sy*s.stride : sy*s.stride+s.w*3]
+		o := j * mw
+		for i := 0; i < mw; i++ {
+			sx := min(x0+i, s.w-1) * 3
+			b, g, r := int32(row[sx]), int32(row[sx+1]), int32(row[sx+2])
+			if cb == nil {
+				yy[o+i] = rgbToY(r, g, b)
+				continue
+			}
+			yy[o+i], cb[o+i], cr[o+i] = rgbToYCbCr(r, g, b)
+		}
+	}
+}
+
+// rgbaSampler reads 8-bit RGBA or NRGBA pixels; alpha is ignored.
+type rgbaSampler struct {
+	pix    []byte
+	stride int
+	w, h   int
+}
+
+func (s *rgbaSampler) load(x0, y0, mw, mh int, yy, cb, cr []int32) {
+	for j := 0; j < mh; j++ {
+		sy := min(y0+j, s.h-1)
+		row := s.pix[sy*s.stride : sy*s.stride+s.w*4]
+		o := j * mw
+		for i := 0; i < mw; i++ {
+			sx := min(x0+i, s.w-1) * 4
+			r, g, b := int32(row[sx]), int32(row[sx+1]), int32(row[sx+2])
+			if cb == nil {
+				yy[o+i] = rgbToY(r, g, b)
+				continue
+			}
+			yy[o+i], cb[o+i], cr[o+i] = rgbToYCbCr(r, g, b)
+		}
+	}
+}
+
+// ycbcrSampler reads planar YCbCr images of any subsample ratio. Chroma is
+// replicated to full resolution; the encoder's decimation then restores the
+// original samples when the ratios match.
+type ycbcrSampler struct {
+	img    *image.YCbCr
+	w, h   int
+	cx, cy int // chroma decimation factors
+}
+
+func (s *ycbcrSampler) reset(img *image.YCbCr) {
+	b := img.Bounds()
+	s.img, s.w, s.h = img, b.Dx(), b.Dy()
+	s.cx, s.cy = 1, 1
+	switch img.SubsampleRatio {
+	case image.YCbCrSubsampleRatio422:
+		s.cx = 2
+	case image.YCbCrSubsampleRatio420:
+		s.cx, s.cy = 2, 2
+	case image.YCbCrSubsampleRatio440:
+		s.cy = 2
+	case image.YCbCrSubsampleRatio411:
+		s.cx = 4
+	case image.YCbCrSubsampleRatio410:
+		s.cx, s.cy = 4, 2
+	}
+}
+
+func (s *ycbcrSampler) load(x0, y0, mw, mh int, yy, cb, cr []int32) {
+	img := s.img
+	b := img.Bounds()
+	// chroma coordinates are relative to the chroma-aligned origin of the image
+	cox, coy := b.Min.X/s.cx, b.Min.Y/s.cy
+	for j := 0; j < mh; j++ {
+		sy := min(y0+j, s.h-1)
+		yrow := img.Y[sy*img.YStride:]
+		crow := ((sy+b.Min.Y)/s.cy - coy) * img.CStride
+		o := j * mw
+		for i := 0; i < mw; i++ {
+			sx := min(x0+i, s.w-1)
+			yy[o+i] = int32(yrow[sx]) - 128
+			if cb != nil {
+				ci := crow + (sx+b.Min.X)/s.cx - cox
+				cb[o+i] = int32(img.Cb[ci]) - 128
+				cr[o+i] = int32(img.Cr[ci]) - 128
+			}
+		}
+	}
+}
+
+// graySampler reads 8-bit grayscale images; chroma is neutral.
+type graySampler struct {
+	pix    []byte
+	stride int
+	w, h   int
+}
+
+func (s *graySampler) load(x0, y0, mw, mh int, yy, cb, cr []int32) {
+	for j := 0; j < mh; j++ {
+		sy := min(y0+j, s.h-1)
+		row := s.pix[sy*s.stride:]
+		o := j * mw
+		for i := 0; i < mw; i++ {
+			yy[o+i] = int32(row[min(x0+i, s.w-1)]) - 128
+			if cb != nil {
+				cb[o+i], cr[o+i] = 0, 0
+			}
+		}
+	}
+}
+
+// imageSampler is the slow path for any other image.Image.
+type imageSampler struct {
+	img  image.Image
+	w, h int
+}
+
+func (s *imageSampler) load(x0, y0, mw, mh int, yy, cb, cr []int32) {
+	b := s.img.Bounds()
+	for j := 0; j < mh; j++ {
+		sy := b.Min.Y + min(y0+j, s.h-1)
+		o := j * mw
+		for i := 0; i < mw; i++ {
+			r, g, bl, _ := s.img.At(b.Min.X+min(x0+i, s.w-1), sy).RGBA()
+			if cb == nil {
+				yy[o+i] = rgbToY(int32(r>>8), int32(g>>8), int32(bl>>8))
+				continue
+			}
+			yy[o+i], cb[o+i], cr[o+i] = rgbToYCbCr(int32(r>>8), int32(g>>8), int32(bl>>8))
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+
+	"Garage48/internal/camera"
+)
+
+// streamParams are the viewer's choices for /stream/{id}.mjpg.
+type streamParams struct {
+	w, h    int     // bounding box, 0 for the source size
+	quality int     // 0 keeps the published frame's quality
+	fps     float64 // 0 for every frame
+	adapt   bool    // step down size and quality when the viewer falls behind
+}
+
+const (
+	maxStreamDim     = 8192
+	maxAdaptLevel    = 5
+	adaptStep        = 0.75 // size factor per level
+	adaptQualityStep = 10
+	adaptMinQuality  = 30
+	adaptBaseQuality = 80 // starting point when the viewer did not pick one
+)
+
+func parseStreamParams(q url.Values) (streamParams, error) {
+	p := streamParams{adapt: true}
+	var err error
+	intParam := func(name string, lo, hi int) int {
+		s := q.Get(name)
+		if s == "" || err != nil {
+			return 0
+		}
+		v, e := strconv.Atoi(s)
+		if e != nil || v < lo || v > hi {
+			err = fmt.Errorf("%s must be between %d and %d", name, lo, hi)
+		}
+		return v
+	}
+	p.w = intParam("w", 1, maxStreamDim)
+	p.h = intParam("h", 1, maxStreamDim)
+	p.quality = intParam("q", 1, 100)
+	if s := q.Get("fps"); s != "" && err == nil {
+		p.fps, err = strconv.ParseFloat(s, 64)
+		if err != nil || p.fps <= 0 || p.fps > 120 || math.IsNaN(p.fps) {
+			err = fmt.Errorf("fps must be between 0 and 120")
+		}
+	}
+	if s := q.Get("adapt"); s != "" && err == nil {
+		p.adapt, err = strconv.ParseBool(s)
+		if err != nil {
+			err = fmt.Errorf("adapt must be a boolean")
+		}
+	}
+	return p, err
+}
+
+// variant returns the rendition for an adaptation level, given the size of
+// the published frames. Sizes are rounded down to multiples of 8 so viewers
+// at the same level on the same camera share encodes.
+func (p streamParams) variant(level int, src image.Point) camera.Variant {
+	v := camera.Variant{MaxW: p.w, MaxH: p.h, Quality: p.quality}
+	if level == 0 {
+		return v
+	}
+	box := src
+	if p.w > 0 || p.h > 0 {
+		box = image.Pt(p.w, p.h)
+		if box.X == 0 || box.X > src.X {
+			box.X = src.X
+		}
+		if box.Y == 0 || box.Y > src.Y {
+			box.Y = src.Y
+		}
+	}
+	f := math.Pow(adaptStep, float64(level))
+	v.MaxW = max(int(float64(box.X)*f)&^7, 8)
+	v.MaxH = max(int(float64(box.Y)*f)&^7, 8)
+	q := p.quality
+	if q == 0 {
+		q = adaptBaseQuality
+	}
+	v.Quality = max(q-adaptQualityStep*level, adaptMinQuality)
+	return v
+}
+
+// viewerRate tracks how much of its time a viewer's connection spends
+// blocked in writes. A link that keeps up returns from writes at once
+// because the socket buffer absorbs the frame; one that does not blocks
+// until the frame has drained, so the busy share approximates how close
+// the viewer is to its throughput limit.
+type viewerRate struct {
+	level    int
+	maxLevel int
+
+	start time.Time // of the current window
+	busy  time.Duration
+	calm  int // consecutive windows well below the limit
+}
+
+const (
+	rateWindow    = 2 * time.Second
+	rateBusyHigh  = 0.6 // step down above this busy share
+	rateBusyLow   = 0.2 // count as calm below this
+	rateCalmSteps = 3   // calm windows before stepping back up
+)
+
+func newViewerRate(adapt bool, now time.Time) *viewerRate {
+	r := &viewerRate{start: now}
+	if adapt {
+		r.maxLevel = maxAdaptLevel
+	}
+	return r
+}
+
+// observe records a frame that took d to write and adjusts the level at the
+// end of each window.
+func (r *viewerRate) observe(d time.Duration, now time.Time) {
+	r.busy += d
+	el := now.Sub(r.start)
+	if el < rateWindow {
+		return
+	}
+	share := r.busy.Seconds() / el.Seconds()
+	r.start, r.busy = now, 0
+	switch {
+	case share > rateBusyHigh:
+		r.level = min(r.level+1, r.maxLevel)
+		r.calm = 0
+	case share < rateBusyLow:
+		r.calm++
+		if r.calm >= rateCalmSteps && r.level > 0 {
+			r.level--
+			r.calm = 0
+		}
+	default:
+		r.calm = 0
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+
+	"Garage48/internal/camera"
 )
 
 type Config struct {
@@ -13,6 +15,7 @@ type Config struct {
 type CameraConfig struct {
 	ID  string `json:"id"`
 	URL string `json:"url"`
+	camera.Options
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -24,5 +27,13 @@ func LoadConfig(path string) (*Config, error) {
 	if err := json.Unmarshal(b, &c); err != nil {
 		return nil, fmt.Errorf("unmarshal: %w", err)
 	}
+	for _, cam := range c.Cameras {
+		if err := cam.Detector.Validate(); err != nil {
+			return nil, fmt.Errorf("camera %s: %w", cam.ID, err)
+		}
+		if err := cam.Filter.Validate(); err != nil {
+			return nil, fmt.Errorf("camera %s: %w", cam.ID, err)
+		}
+	}
 	return &c, nil
 }
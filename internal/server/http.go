@@ -1,14 +1,24 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"image"
+	"image/png"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"Garage48/internal/camera"
+	"Garage48/internal/dataset"
+	"Garage48/internal/detector"
+	"Garage48/internal/jpegfast"
 
 	"github.com/gorilla/mux"
 )
@@ -17,9 +27,11 @@ type Server struct {
 	httpServer *http.Server
 	cfg        *Config
 	reg        *camera.Registry
+	detectors  *detector.Pool
+	dataset    *dataset.Store // nil when training capture is off
 }
 
-func New(bind string, cfg *Config, reg *camera.Registry) *Server {
+func New(bind string, cfg *Config, reg *camera.Registry, detectors *detector.Pool, ds *dataset.Store) *Server {
 	r := mux.NewRouter()
 	s := &Server{
 		httpServer: &http.Server{
@@ -27,12 +39,24 @@ func New(bind string, cfg *Config, reg *camera.Registry) *Server {
 			Handler:           r,
 			ReadHeaderTimeout: 10 * time.Second,
 		},
-		cfg: cfg,
-		reg: reg,
+		cfg:       cfg,
+		reg:       reg,
+		detectors: detectors,
+		dataset:   ds,
 	}
 	r.HandleFunc("/", s.handleIndex).Methods("GET")
 	r.HandleFunc("/snapshot/{id}.jpg", s.handleSnapshot).Methods("GET")
 	r.HandleFunc("/stream/{id}.mjpg", s.handleMJPEG).Methods("GET")
+	r.HandleFunc("/mosaic.mjpg", s.handleMosaic).Methods("GET")
+	r.HandleFunc("/api/cameras/{id}/status", s.handleStatus).Methods("GET")
+	r.HandleFunc("/api/detectors", s.handleDetectors).Methods("GET")
+	r.HandleFunc("/api/cameras/{id}/capture", s.handleCapture).Methods("POST")
+	r.HandleFunc("/api/dataset", s.handleDataset).Methods("GET")
+	r.HandleFunc("/api/dataset/export", s.handleDatasetExport).Methods("GET")
+	r.HandleFunc("/api/cameras/{id}/tamper/{event}.jpg", s.handleTamperSnapshot).Methods("GET")
+	r.HandleFunc("/api/cameras/{id}/tamper/reset", s.handleTamperReset).Methods("POST")
+	r.HandleFunc("/api/cameras/{id}/transform", s.handleGetTransform).Methods("GET")
+	r.HandleFunc("/api/cameras/{id}/transform", s.handleSetTransform).Methods("PUT", "POST")
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("web/static"))))
 	return s
 }
@@ -52,6 +76,18 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	_ = tpl.Execute(w, data)
 }
 
+// handleSnapshot returns the latest frame. Query parameters:
+//
+//	w, h       bound the size; never upscales
+//	fit        contain (default) or cover, which crops to fill w x h
+//	q          JPEG quality 1..100
+//	raw=1      the camera's own frame, without annotations or transform
+//	format     jpeg (default) or png
+//	wait=seq   hold the request until a frame newer than seq exists
+//
+// The ETag is the frame's sequence number, so a poller sending
+// If-None-Match gets 304 until a new frame arrives. A wait that times out
+// also answers 304.
 func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 	cam := s.reg.Get(id)
@@ -59,17 +95,316 @@ func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	jpg := cam.LatestJPEG()
-	if len(jpg) == 0 {
+	q := r.URL.Query()
+	p, err := parseStreamParams(q)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	v := camera.Variant{MaxW: p.w, MaxH: p.h, Quality: p.quality}
+	switch q.Get("fit") {
+	case "", "contain":
+	case "cover":
+		v.Fill = true
+	default:
+		http.Error(w, "fit must be contain or cover", 400)
+		return
+	}
+	if rs := q.Get("raw"); rs != "" {
+		if v.Raw, err = strconv.ParseBool(rs); err != nil {
+			http.Error(w, "raw must be a boolean", 400)
+			return
+		}
+	}
+	format := q.Get("format")
+	switch format {
+	case "", "jpeg", "jpg":
+		format = "jpeg"
+	case "png":
+	default:
+		http.Error(w, "format must be jpeg or png", 400)
+		return
+	}
+
+	if ws := q.Get("wait"); ws != "" {
+		since, err := strconv.ParseUint(ws, 10, 64)
+		if err != nil {
+			http.Error(w, "bad wait sequence", 400)
+			return
+		}
+		if !waitFrame(r.Context(), cam, since, snapshotWait) {
+			if r.Context().Err() == nil {
+				notModified(w, cam.Seq())
+			}
+			return
+		}
+	}
+	// checked before rendering so pollers cost nothing between frames
+	if seq := cam.Seq(); seq > 0 && match(r, frameETag(seq)) {
+		notModified(w, seq)
+		return
+	}
+
+	var (
+		body []byte
+		seq  uint64
+	)
+	if format == "png" {
+		var img image.Image
+		img, seq, err = cam.VariantImage(v)
+		if err == nil && img != nil {
+			var buf bytes.Buffer
+			enc := png.Encoder{CompressionLevel: png.BestSpeed}
+			if err = enc.Encode(&buf, img); err == nil {
+				body = buf.Bytes()
+			}
+		}
+	} else {
+		body, seq, err = cam.VariantJPEG(v)
+	}
+	switch {
+	case errors.Is(err, camera.ErrRawUnavailable):
+		http.Error(w, err.Error(), 403)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), 500)
+		return
+	case len(body) == 0:
 		http.Error(w, "no frame", 503)
 		return
 	}
+	w.Header().Set("Content-Type", "image/"+format)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", frameETag(seq))
+	w.Header().Set("X-Frame-Seq", strconv.FormatUint(seq, 10))
+	w.WriteHeader(200)
+	_, _ = w.Write(body)
+}
+
+// snapshotWait bounds ?wait= long-polls below common proxy idle timeouts.
+const snapshotWait = 25 * time.Second
+
+// waitFrame blocks until cam has a frame newer than since, reporting false
+// on timeout or when the request goes away.
+func waitFrame(ctx context.Context, cam *camera.Camera, since uint64, timeout time.Duration) bool {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	for cam.Seq() <= since {
+		select {
+		case <-cam.WaitNext(cam.Seq()):
+		case <-t.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+func frameETag(seq uint64) string { return `"` + strconv.FormatUint(seq, 10) + `"` }
+
+// match reports whether the request's If-None-Match lists etag or *.
+func match(r *http.Request, etag string) bool {
+	for _, t := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == etag || t == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func notModified(w http.ResponseWriter, seq uint64) {
+	w.Header().Set("ETag", frameETag(seq))
+	w.Header().Set("X-Frame-Seq", strconv.FormatUint(seq, 10))
+	w.WriteHeader(http.StatusNotModified)
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	cam := s.reg.Get(id)
+	if cam == nil {
+		http.NotFound(w, r)
+		return
+	}
+	st := cam.Status()
+	if st.Tamper != nil {
+		for i := range st.Tamper.Events {
+			st.Tamper.Events[i].Snapshot = fmt.Sprintf("/api/cameras/%s/tamper/%d.jpg", id, st.Tamper.Events[i].ID)
+		}
+	}
+	writeJSON(w, st)
+}
+
+// handleDetectors reports the state of every sidecar in the shared pool.
+func (s *Server) handleDetectors(w http.ResponseWriter, r *http.Request) {
+	if s.detectors == nil {
+		writeJSON(w, []detector.MemberStatus{})
+		return
+	}
+	writeJSON(w, s.detectors.Status())
+}
+
+// handleCapture saves the camera's latest detected frame as a training sample.
+func (s *Server) handleCapture(w http.ResponseWriter, r *http.Request) {
+	cam := s.reg.Get(mux.Vars(r)["id"])
+	if cam == nil {
+		http.NotFound(w, r)
+		return
+	}
+	smp, err := cam.Capture()
+	switch {
+	case errors.Is(err, camera.ErrRawUnavailable):
+		http.Error(w, err.Error(), 403)
+		return
+	case errors.Is(err, camera.ErrNoDataset), errors.Is(err, camera.ErrNoFrame):
+		http.Error(w, err.Error(), 409)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSONStatus(w, 201, smp)
+}
+
+// handleDataset lists the captured samples.
+func (s *Server) handleDataset(w http.ResponseWriter, r *http.Request) {
+	if s.dataset == nil {
+		http.Error(w, camera.ErrNoDataset.Error(), 404)
+		return
+	}
+	samples, err := s.dataset.List()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, samples)
+}
+
+// handleDatasetExport downloads the samples as a zip archive. Query
+// parameters:
+//
+//	format     yolo (default), coco or voc
+//	val        fraction of samples in the validation split, default 0.2
+//	cams       comma-separated cameras to include, default all
+func (s *Server) handleDatasetExport(w http.ResponseWriter, r *http.Request) {
+	if s.dataset == nil {
+		http.Error(w, camera.ErrNoDataset.Error(), 404)
+		return
+	}
+	q := r.URL.Query()
+	opts := dataset.ExportOptions{Format: q.Get("format"), Val: 0.2}
+	if opts.Format == "" {
+		opts.Format = dataset.FormatYOLO
+	}
+	switch opts.Format {
+	case dataset.FormatYOLO, dataset.FormatCOCO, dataset.FormatVOC:
+	default:
+		http.Error(w, "format must be yolo, coco or voc", 400)
+		return
+	}
+	if v := q.Get("val"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			http.Error(w, "val must be between 0 and 1", 400)
+			return
+		}
+		opts.Val = f
+	}
+	for _, id := range strings.Split(q.Get("cams"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			opts.Cameras = append(opts.Cameras, id)
+		}
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="dataset-%s.zip"`, opts.Format))
+	// the archive streams out; a failure midway can only cut it short
+	if err := s.dataset.Export(w, opts); err != nil {
+		log.Printf("dataset export: %v", err)
+	}
+}
+
+func (s *Server) handleTamperSnapshot(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	cam := s.reg.Get(vars["id"])
+	if cam == nil {
+		http.NotFound(w, r)
+		return
+	}
+	ev, err := strconv.ParseUint(vars["event"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad event id", 400)
+		return
+	}
+	jpg := cam.TamperSnapshot(ev)
+	if len(jpg) == 0 {
+		http.NotFound(w, r)
+		return
+	}
 	w.Header().Set("Content-Type", "image/jpeg")
-	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
 	w.WriteHeader(200)
 	_, _ = w.Write(jpg)
 }
 
+func (s *Server) handleTamperReset(w http.ResponseWriter, r *http.Request) {
+	cam := s.reg.Get(mux.Vars(r)["id"])
+	if cam == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !cam.ResetTamper() {
+		http.Error(w, "tamper detection disabled", 409)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (s *Server) handleGetTransform(w http.ResponseWriter, r *http.Request) {
+	cam := s.reg.Get(mux.Vars(r)["id"])
+	if cam == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, cam.Transform())
+}
+
+func (s *Server) handleSetTransform(w http.ResponseWriter, r *http.Request) {
+	cam := s.reg.Get(mux.Vars(r)["id"])
+	if cam == nil {
+		http.NotFound(w, r)
+		return
+	}
+	// Start from the current transform so clients may send only the fields they change.
+	t := cam.Transform()
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, fmt.Sprintf("bad transform: %v", err), 400)
+		return
+	}
+	if err := cam.SetTransform(t); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	writeJSON(w, cam.Transform())
+}
+
+func writeJSON(w http.ResponseWriter, v any) { writeJSONStatus(w, 200, v) }
+
+// writeJSONStatus is writeJSON with a status code; the headers go out with
+// it, so they are set first.
+func writeJSONStatus(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("json write error: %v", err)
+	}
+}
+
+// handleMJPEG streams a camera as multipart JPEG. Optional query parameters
+// pick a rendition: w and h bound the size, q sets the JPEG quality and fps
+// caps the frame rate. Unless adapt=0, viewers whose connection cannot keep
+// up are stepped down to smaller, more compressed frames and back up once
+// it recovers; slow viewers skip frames rather than queue them.
 func (s *Server) handleMJPEG(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 	cam := s.reg.Get(id)
@@ -77,11 +412,22 @@ func (s *Server) handleMJPEG(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	p, err := parseStreamParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
 
 	mw := NewMJPEGWriter(w)
 	notify := r.Context().Done()
 	flusher, _ := w.(http.Flusher)
 
+	rate := newViewerRate(p.adapt, time.Now())
+	var minGap time.Duration
+	if p.fps > 0 {
+		minGap = time.Duration(float64(time.Second) / p.fps)
+	}
+	var lastSent time.Time
 	seq := cam.Seq()
 	for {
 		select {
@@ -89,10 +435,21 @@ func (s *Server) handleMJPEG(w http.ResponseWriter, r *http.Request) {
 			return
 		case <-cam.WaitNext(seq):
 			seq = cam.Seq()
-			frame := cam.LatestJPEG()
-			if len(frame) == 0 {
+			// a little slack so a camera at exactly the requested rate is not halved
+			if minGap > 0 && time.Since(lastSent) < minGap*9/10 {
 				continue
 			}
+			var src image.Point
+			if rate.level > 0 {
+				if hdr, err := jpegfast.DecodeHeader(cam.LatestJPEG()); err == nil {
+					src = image.Pt(hdr.Width, hdr.Height)
+				}
+			}
+			frame, _, err := cam.VariantJPEG(p.variant(rate.level, src))
+			if err != nil || len(frame) == 0 {
+				continue
+			}
+			start := time.Now()
 			if err := mw.WriteFrame(frame); err != nil {
 				log.Printf("mjpeg write error: %v", err)
 				return
@@ -100,6 +457,61 @@ func (s *Server) handleMJPEG(w http.ResponseWriter, r *http.Request) {
 			if flusher != nil {
 				flusher.Flush()
 			}
+			now := time.Now()
+			rate.observe(now.Sub(start), now)
+			lastSent = start
+		}
+	}
+}
+
+// handleMosaic streams several cameras as one grid:
+// ?cams=cam1,cam2,cam3&layout=2x2&w=1920. layout and w are optional. All
+// viewers of the same mosaic share one composition and encode.
+func (s *Server) handleMosaic(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var spec camera.MosaicSpec
+	for _, id := range strings.Split(q.Get("cams"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			spec.Cams = append(spec.Cams, id)
+		}
+	}
+	if l := q.Get("layout"); l != "" {
+		if _, err := fmt.Sscanf(l, "%dx%d", &spec.Cols, &spec.Rows); err != nil {
+			http.Error(w, "layout must look like 2x2", 400)
+			return
+		}
+	}
+	if ws := q.Get("w"); ws != "" {
+		v, err := strconv.Atoi(ws)
+		if err != nil {
+			http.Error(w, "bad width", 400)
+			return
+		}
+		spec.Width = v
+	}
+	m, release, err := s.reg.Mosaic(spec)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	defer release()
+
+	mw := NewMJPEGWriter(w)
+	flusher, _ := w.(http.Flusher)
+	var seq uint64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-m.WaitNext(seq):
+			seq = m.Seq()
+			if err := mw.WriteFrame(m.LatestJPEG()); err != nil {
+				log.Printf("mosaic write error: %v", err)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
 		}
 	}
 }
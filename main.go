@@ -7,10 +7,13 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"Garage48/internal/camera"
+	"Garage48/internal/dataset"
+	"Garage48/internal/detector"
 	"Garage48/internal/server"
 )
 
@@ -18,7 +21,9 @@ func main() {
 	configPath := flag.String("config", "config.json", "path to config.json")
 	bind := flag.String("bind", ":8080", "HTTP bind address")
 	maxFPS := flag.Int("fps", 15, "max processing FPS per camera")
-	detectorURL := flag.String("detector", "http://127.0.0.1:9000", "object detector base URL (Python sidecar)")
+	detectorURL := flag.String("detector", "http://127.0.0.1:9000", "object detector base URL (Python sidecar); comma-separate several to load-balance, or \"motion\" for the built-in motion detector")
+	datasetDir := flag.String("dataset", "", "directory for training samples; empty disables capture")
+	detectBatch := flag.Int("detect-batch", 8, "max frames per batched sidecar request; 0 sends one request per camera")
 	flag.Parse()
 
 	cfg, err := server.LoadConfig(*configPath)
@@ -30,8 +35,51 @@ func main() {
 	}
 
 	// Create registry with factory that builds pure-Go MJPEG cameras using the detector HTTP endpoint.
+	opts := make(map[string]camera.Options, len(cfg.Cameras))
+	for _, c := range cfg.Cameras {
+		opts[c.ID] = c.Options
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	// Without a sidecar every camera gets a motion detector of its own, as
+	// each learns its scene.
+	var pool *detector.Pool
+	var sched *detector.Scheduler
+	if *detectorURL != detector.KindMotion {
+		var urls []string
+		for _, u := range strings.Split(*detectorURL, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				urls = append(urls, u)
+			}
+		}
+		pool = detector.NewPool(urls, detector.PoolConfig{})
+		defer pool.Close()
+		// Cameras on the default sidecars share one scheduler so their frames
+		// reach the model in batches.
+		if *detectBatch > 0 {
+			sched = detector.NewScheduler(pool, detector.SchedulerConfig{MaxBatch: *detectBatch})
+			go sched.Run(ctx)
+		}
+	}
+	var ds *dataset.Store
+	if *datasetDir != "" {
+		if ds, err = dataset.Open(*datasetDir); err != nil {
+			log.Fatalf("dataset: %v", err)
+		}
+	}
 	reg := camera.NewRegistry(func(id, url string) *camera.Camera {
-		return camera.NewCamera(id, url, *detectorURL, *maxFPS)
+		var det detector.Detector = pool
+		if pool == nil {
+			det = detector.NewMotion(detector.MotionConfig{})
+		}
+		cam := camera.NewCamera(id, url, det, *maxFPS, opts[id])
+		if d := opts[id].Detector; sched != nil && (d.Kind == "" || d.Kind == detector.KindSidecar) && d.URL == "" {
+			cam.UseScheduler(sched)
+		}
+		if ds != nil {
+			cam.UseDataset(ds)
+		}
+		return cam
 	})
 	for _, c := range cfg.Cameras {
 		if err := reg.AddCamera(c.ID, c.URL); err != nil {
@@ -42,7 +90,7 @@ func main() {
 	}
 	defer reg.Close()
 
-	srv := server.New(*bind, cfg, reg)
+	srv := server.New(*bind, cfg, reg, pool, ds)
 	go func() {
 		if err := srv.ListenAndServe(); err != nil {
 			log.Printf("http server stopped: %v", err)
@@ -54,7 +102,7 @@ func main() {
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 	<-sig
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	_ = srv.Shutdown(ctx)
+	_ = srv.Shutdown(sctx)
 }